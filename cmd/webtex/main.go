@@ -0,0 +1,539 @@
+// webtex renders Markdown documents containing LaTeX into HTML.
+//
+// Usage:
+//
+//	webtex [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	build <src> <dst>   render every document beneath src into dst
+//	render <file.md>    render a single document to STDOUT
+//	watch <src> <dst>   build, then rebuild documents as they change
+//	serve <src> <dst>   build, then serve dst over HTTP, reloading on change
+//
+// Run `webtex <command> -h` for the flags accepted by each command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/beautifultovarisch/webtex/internal/chunk"
+	"github.com/beautifultovarisch/webtex/internal/ignore"
+	"github.com/beautifultovarisch/webtex/internal/livereload"
+	"github.com/beautifultovarisch/webtex/internal/logger"
+	"github.com/beautifultovarisch/webtex/internal/texrender"
+	"github.com/beautifultovarisch/webtex/internal/watcher"
+	"github.com/beautifultovarisch/webtex/pkg/build"
+	"github.com/beautifultovarisch/webtex/pkg/render"
+)
+
+const usage = `Usage: webtex [flags] <command> [arguments]
+
+Commands:
+  build <src> <dst>   render every document beneath src into dst
+  render <file.md>    render a single document to STDOUT
+  watch <src> <dst>   build, then rebuild documents as they change
+  serve <src> <dst>   build, then serve dst over HTTP, reloading on change
+
+Flags:
+`
+
+// Returned for invalid invocations, which exit with status 2 rather than 1.
+var errUsage = errors.New("invalid usage")
+
+// Flags common to every command.
+type config struct {
+	debug    bool
+	workers  int
+	cacheDir string
+	noCache  bool
+	purge    bool
+	envs     string
+	sprite   bool
+	preamble string
+	macros   string
+	engine   string
+	backend  string
+	dvi      bool
+	fonts    bool
+	timeout  time.Duration
+	force    bool
+	jobs     int
+	pretty   bool
+	link     bool
+	include  string
+	exclude  string
+}
+
+func (c *config) register(fs *flag.FlagSet) {
+	cacheDir, err := texrender.DefaultCacheDir()
+	if err != nil {
+		cacheDir = ""
+	}
+
+	fs.BoolVar(&c.debug, "debug", false, "log debugging information")
+	fs.IntVar(&c.workers, "workers", 0, "maximum number of concurrent TeX processes (default: number of CPUs)")
+	fs.IntVar(&c.jobs, "jobs", 0, "maximum number of documents rendered concurrently (default: number of CPUs)")
+	fs.StringVar(&c.cacheDir, "cache", cacheDir, "directory in which to cache rendered SVGs")
+	fs.BoolVar(&c.noCache, "nocache", false, "render every formula, bypassing the cache")
+	fs.BoolVar(&c.purge, "purge", false, "empty the cache before rendering")
+	fs.BoolVar(&c.pretty, "pretty", false, "write each page as the index.html of its own directory")
+	fs.BoolVar(&c.link, "link", false, "hard-link assets into the output rather than copying them")
+	fs.StringVar(&c.include, "include", "", "comma separated patterns of assets to copy, e.g. *.png,*.pdf (default: all)")
+	fs.StringVar(&c.exclude, "exclude", "", "comma separated patterns of assets not to copy, e.g. drafts/*")
+	fs.BoolVar(&c.force, "force", false, "render every document, even if unchanged since the last build")
+	fs.StringVar(&c.engine, "engine", string(texrender.DefaultEngine), "TeX engine: pdflatex, xelatex or lualatex")
+	fs.StringVar(&c.backend, "backend", "", "SVG converter: pdf2svg or dvisvgm (default: pdf2svg if installed)")
+	fs.BoolVar(&c.dvi, "dvi", false, "convert DVI rather than PDF output (dvisvgm only)")
+	fs.BoolVar(&c.fonts, "fonts", false, "embed WOFF2 fonts rather than drawing glyphs as paths (dvisvgm only)")
+	fs.DurationVar(&c.timeout, "timeout", time.Minute, "kill TeX if rendering a single formula takes longer (0 for no limit)")
+	fs.StringVar(&c.preamble, "preamble", "", "file of LaTeX to add to the preamble of every formula")
+	fs.StringVar(&c.macros, "macros", "", "file of LaTeX macro definitions available to every formula")
+	fs.BoolVar(&c.sprite, "sprite", false, "define each glyph once per page rather than once per formula")
+	fs.StringVar(&c.envs, "envs", strings.Join(chunk.DefaultEnvironments, ","), "comma separated LaTeX environments to render without math delimiters")
+}
+
+// Split the comma separated list [s], dropping empty items. The result is
+// never nil.
+func splitList(s string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Construct the build options described by the flags, rendering documents
+// with [opts].
+func (c *config) buildOptions(opts render.Options) build.Options {
+	return build.Options{
+		Render:     opts,
+		Force:      c.force,
+		Workers:    c.jobs,
+		PrettyURLs: c.pretty,
+		Link:       c.link,
+		Include:    splitList(c.include),
+		Exclude:    splitList(c.exclude),
+	}
+}
+
+// Construct the render options described by the flags.
+func (c *config) renderOptions() (render.Options, error) {
+	opts := render.Options{Workers: c.workers, Environments: splitList(c.envs), Sprite: c.sprite}
+
+	engine, err := texrender.ParseEngine(c.engine)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Tex.Engine = engine
+
+	backend, err := texrender.ParseBackend(c.backend)
+	if err != nil {
+		return opts, err
+	}
+
+	// Embedded fonts leave no glyph outlines for the sprite to share.
+	if c.sprite && c.fonts {
+		return opts, errors.New("-sprite can't be combined with -fonts")
+	}
+
+	opts.Tex.Backend = backend
+	opts.Tex.DVI = c.dvi
+	opts.Tex.Fonts = c.fonts
+	opts.Tex.Timeout = c.timeout
+
+	if c.preamble != "" {
+		preamble, err := os.ReadFile(c.preamble)
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Tex.Preamble = string(preamble)
+	}
+
+	if c.macros != "" {
+		macros, err := os.ReadFile(c.macros)
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Tex.Macros = string(macros)
+	}
+
+	if c.noCache || c.cacheDir == "" {
+		return opts, nil
+	}
+
+	cache, err := texrender.NewCache(c.cacheDir, 0)
+	if err != nil {
+		return opts, err
+	}
+
+	if c.purge {
+		if err := cache.Purge(); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.Tex.Cache = cache
+
+	return opts, nil
+}
+
+// Parse the flags and positional arguments of a command, which must number
+// exactly [nargs].
+func parse(fs *flag.FlagSet, args []string, nargs int) (*config, error) {
+	var c config
+	c.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+
+	if fs.NArg() != nargs {
+		fs.Usage()
+
+		return nil, errUsage
+	}
+
+	logger.SetDebug(c.debug)
+
+	return &c, nil
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: webtex %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+func buildCmd(ctx context.Context, args []string) error {
+	fs := newFlagSet("build", "<src> <dst>")
+
+	c, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	opts, err := c.renderOptions()
+	if err != nil {
+		return err
+	}
+
+	return build.Build(ctx, fs.Arg(0), fs.Arg(1), c.buildOptions(opts))
+}
+
+func renderCmd(ctx context.Context, args []string) error {
+	fs := newFlagSet("render", "<file.md>")
+
+	c, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	opts, err := c.renderOptions()
+	if err != nil {
+		return err
+	}
+
+	md, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer md.Close()
+
+	if err := render.New(opts).RenderDoc(ctx, md, os.Stdout); err != nil {
+		return fmt.Errorf("%s:%w", fs.Arg(0), err)
+	}
+
+	return nil
+}
+
+// Rebuild the documents beneath [src] as they change until [ctx] is done,
+// reporting failures without stopping. [rebuilt] is invoked after each
+// successful rebuild.
+//
+// Changes made during a rebuild cancel it, and the paths it was rebuilding are
+// rebuilt again along with them.
+func watch(ctx context.Context, src, dst string, opts build.Options, rebuilt func()) error {
+	ig, err := ignore.Load(src)
+	if err != nil {
+		return err
+	}
+
+	// Reloaded as the ignore file changes. Directories it no longer ignores are
+	// only watched once restarted, however.
+	var matcher atomic.Pointer[ignore.Matcher]
+	matcher.Store(ig)
+
+	ignoreFile := filepath.Join(src, ignore.File)
+
+	// Writing the output directory, should it lie within src, doesn't change
+	// the site.
+	out, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+
+	w, err := watcher.New(src, watcher.Options{Ignore: func(path string) bool {
+		// Changes to the ignore file itself rebuild the site.
+		if path == ignoreFile {
+			return false
+		}
+
+		if abs, err := filepath.Abs(path); err == nil && (abs == out || strings.HasPrefix(abs, out+string(filepath.Separator))) {
+			return true
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return false
+		}
+
+		fi, err := os.Lstat(path)
+
+		return matcher.Load().Ignored(rel, err == nil && fi.IsDir())
+	}})
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	fmt.Fprintf(os.Stderr, "Watching %s for changes\n", src)
+
+	var (
+		pending []string   // Paths of the rebuild in progress, if any.
+		cancel  func()     // Cancels the rebuild in progress.
+		done    chan error // Receives the result of the rebuild in progress.
+	)
+
+	// Cancel any rebuild in progress and wait for it to stop.
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			<-done
+		}
+
+		pending, cancel, done = nil, nil, nil
+	}
+	defer stop()
+
+	for {
+		select {
+		case events := <-w.Events:
+			paths := slices.Clone(pending)
+			for _, e := range events {
+				logger.Log("%s %s", e.Op, e.Path)
+
+				paths = append(paths, e.Path)
+
+				if e.Path == ignoreFile {
+					if ig, err := ignore.Load(src); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+					} else {
+						matcher.Store(ig)
+					}
+				}
+			}
+
+			if cancel != nil {
+				logger.Log("Cancelling rebuild superseded by newer changes")
+			}
+
+			stop()
+
+			slices.Sort(paths)
+			pending = slices.Compact(paths)
+
+			rctx, rcancel := context.WithCancel(ctx)
+			cancel, done = rcancel, make(chan error, 1)
+
+			go func(paths []string, done chan<- error) {
+				done <- build.Rebuild(rctx, src, dst, paths, opts)
+			}(pending, done)
+		case err := <-done:
+			cancel()
+			pending, cancel, done = nil, nil, nil
+
+			if err != nil {
+				// Errors are expected while authoring, keep watching.
+				fmt.Fprintln(os.Stderr, err)
+
+				continue
+			}
+
+			if rebuilt != nil {
+				rebuilt()
+			}
+		case err := <-w.Errors:
+			// Missing an event costs at worst a stale page, keep watching.
+			fmt.Fprintln(os.Stderr, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func watchCmd(ctx context.Context, args []string) error {
+	fs := newFlagSet("watch", "<src> <dst>")
+
+	c, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	opts, err := c.renderOptions()
+	if err != nil {
+		return err
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	bopts := c.buildOptions(opts)
+
+	// A broken document shouldn't prevent us from watching for its fix.
+	if err := build.Build(ctx, src, dst, bopts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	// Only the initial build is forced; rebuilds render whatever changed.
+	bopts.Force = false
+
+	return watch(ctx, src, dst, bopts, nil)
+}
+
+func serveCmd(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve", "<src> <dst>")
+
+	addr := fs.String("addr", "localhost:8080", "address on which to listen")
+
+	c, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	renderOpts, err := c.renderOptions()
+	if err != nil {
+		return err
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	// Pages built for the development server reload themselves on rebuild.
+	opts := c.buildOptions(renderOpts)
+	opts.LiveReload = livereload.Path
+
+	if err := build.Build(ctx, src, dst, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	// Only the initial build is forced; rebuilds render whatever changed.
+	opts.Force = false
+
+	broker := livereload.New()
+
+	mux := http.NewServeMux()
+	mux.Handle(livereload.Path, broker)
+	mux.Handle("/", http.FileServer(http.Dir(dst)))
+
+	// Requests share the fate of the server, so that interrupting it also ends
+	// any live reload connections rather than waiting on them to shut down.
+	srv := &http.Server{
+		Addr:        *addr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errs := make(chan error, 2)
+
+	go func() {
+		errs <- watch(ctx, src, dst, opts, broker.Reload)
+	}()
+
+	go func() {
+		fmt.Fprintf(os.Stderr, "Serving %s on http://%s\n", dst, *addr)
+
+		errs <- srv.ListenAndServe()
+	}()
+
+	err = <-errs
+
+	// Whether interrupted or the watcher failed, stop serving.
+	if serr := srv.Shutdown(context.Background()); err == nil || errors.Is(err, http.ErrServerClosed) {
+		err = serr
+	}
+
+	return err
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	// Accept -debug ahead of the command as well, e.g. webtex -debug build ...
+	debug := flag.Bool("debug", false, "log debugging information")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"build":  buildCmd,
+		"render": renderCmd,
+		"watch":  watchCmd,
+		"serve":  serveCmd,
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "webtex: unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	args := flag.Args()[1:]
+	if *debug {
+		args = append([]string{"-debug"}, args...)
+	}
+
+	// Interrupting webtex kills any TeX in flight rather than leaving it behind.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Being interrupted is how watch and serve are expected to stop.
+	if err := cmd(ctx, args); err != nil && !errors.Is(err, context.Canceled) {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+
+		fmt.Fprintf(os.Stderr, "webtex: %s\n", err)
+		os.Exit(1)
+	}
+}
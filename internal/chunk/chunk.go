@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"strings"
-	"unicode"
+	"sync"
+
+	"github.com/beautifultovarisch/webtex/internal/frontmatter"
+	"github.com/beautifultovarisch/webtex/internal/logger"
 )
 
 // ChunkType represents the nature of the contiguous block of content contained
@@ -20,6 +23,9 @@ const (
 	BLOCK
 	FENCE
 	INLINE
+	ENV
+	FRONT
+	MACROS
 )
 
 func (c ChunkType) String() string {
@@ -30,205 +36,820 @@ func (c ChunkType) String() string {
 		BLOCK:  "Block",
 		FENCE:  "Fence",
 		INLINE: "Inline",
+		ENV:    "Environment",
+		FRONT:  "Frontmatter",
+		MACROS: "Macros",
 	}
 
 	return t[c]
 }
 
+// MacrosInfo is the info string of fenced code blocks which define LaTeX macros
+// for the rest of the document, e.g.
+//
+//	```latex-macros
+//	\newcommand{\R}{\mathbb{R}}
+//	```
+const MacrosInfo = "latex-macros"
+
+// TexInfo is the info string of fenced code blocks rendered as LaTeX, when
+// followed by attributes configuring the render, e.g.
+//
+//	```tex engine=lualatex
+//	\begin{tikzpicture} ... \end{tikzpicture}
+//	```
+const TexInfo = "tex"
+
+// DefaultEnvironments are the LaTeX environments recognised outside of any math
+// delimiters unless configured otherwise.
+var DefaultEnvironments = []string{
+	"align", "align*",
+	"alignat", "alignat*",
+	"equation", "equation*",
+	"flalign", "flalign*",
+	"gather", "gather*",
+	"multline", "multline*",
+	"tikzpicture",
+}
+
 // Chunk is a contiguous block of either Markdown or LaTeX content.
 type Chunk struct {
 	T       ChunkType // Indicates whether the Chunk is markdown or LaTeX
 	Content string    // The raw contents of the chunk of text.
+	Start   Position  // Start is the position of the first character of the chunk, including delimiters.
+	End     Position  // End is the position immediately after the chunk, including delimiters.
+
+	// Attrs holds the attributes of fenced LaTeX blocks, e.g. engine=lualatex.
+	Attrs map[string]string
 }
 
 func (c Chunk) String() string {
 	return fmt.Sprintf("{%s %s}", c.T.String(), c.Content)
 }
 
-// Peek at the next character to determine whether we have one of (possible)
+// ASCII punctuation may be escaped with a backslash, as in CommonMark.
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// Determine the length of the inline LaTeX at the start of [src], including its
+// delimiters, or 0 if [src] doesn't begin with inline LaTeX. Following Pandoc:
+//
+//   - The opening '$' must be followed immediately by a non-space character
+//   - The closing '$' must be preceded immediately by a non-space character
+//   - The closing '$' must not be followed immediately by a digit
+//   - Inline LaTeX may not span a blank line
+//
+// Thus prose such as "between $20 and $30" is left alone. An escaped dollar
+// sign (\$) never terminates inline LaTeX.
+func inlineLen(src string) int {
+	if len(src) < 2 || isSpace(src[1]) || src[1] == '$' {
+		return 0
+	}
+
+	for i := 1; i < len(src); {
+		switch c := src[i]; {
+		case c == '$':
+			if i+1 < len(src) && isDigit(src[i+1]) {
+				return 0
+			}
+
+			return i + 1
+		case c == '\\':
+			i += 2
+		case isSpace(c):
+			j := i
+			for j < len(src) && isSpace(src[j]) {
+				j++
+			}
+
+			if (j < len(src) && src[j] == '$') || strings.Count(src[i:j], "\n") > 1 {
+				return 0
+			}
+
+			i = j
+		default:
+			i++
+		}
+	}
+
+	return 0
+}
+
+// Reports whether a blank line begins at the newline src[i].
+func blankLine(src string, i int) bool {
+	if src[i] != '\n' {
+		return false
+	}
+
+	return strings.HasPrefix(strings.TrimLeft(src[i+1:], " \t\r"), "\n")
+}
+
+// Determine the length of the LaTeX delimited by [open] and [close] at the start
+// of [src], including its delimiters, or 0 if it is never closed. Inline LaTeX
+// may not span a blank line.
+func delimitedLen(src, open, close string, inline bool) int {
+	n, _ := scanDelimited(src, open, close, inline)
+
+	return n
+}
+
+// As delimitedLen, additionally reporting how far into [src] the search for
+// [close] went.
+func scanDelimited(src, open, close string, inline bool) (int, int) {
+	i := len(open)
+
+	for i < len(src) {
+		switch {
+		case strings.HasPrefix(src[i:], close):
+			return i + len(close), i
+		case src[i] == '\\':
+			i += 2
+		case inline && blankLine(src, i):
+			return 0, i
+		default:
+			i++
+		}
+	}
+
+	return 0, len(src)
+}
+
+// span is a range of offsets within a document, from [from] up to [until].
+type span struct{ from, until int }
+
+// As delimitedLen, for the LaTeX at [off]. Where LaTeX is never closed, no
+// other LaTeX opened before the search for [close] ended can be either, so it
+// isn't searched for again: otherwise every unclosed \[ would search the rest
+// of the document.
+func (l *Lexer) delimitedLen(off int, open, close string, inline bool) int {
+	if u, ok := l.unclosed[close]; ok && u.from <= off && off < u.until {
+		return 0
+	}
+
+	n, end := scanDelimited(l.src[off:], open, close, inline)
+	if n == 0 {
+		l.unclosed[close] = span{off, off + end}
+	}
+
+	return n
+}
+
+// Examine the start of [src] to determine whether we have one of (possible)
 // delimiters:
 //
 //   - $
-//   - `
+//   - ` (or any run of backticks)
 //   - $$
-//   - ```
-func checkType(md *bufio.Reader) (ChunkType, error) {
-	peek, err := md.Peek(1)
-	if err != nil {
-		return NULL, err
-	}
+//   - ``` or ~~~ (or any longer fence)
+//   - 4 spaces of indentation
+//   - \( \)
+//   - \[ \]
+//   - \begin{env} \end{env}
+//   - --- (frontmatter, only at the start of the document and only if valid)
+//   - ```latex-macros
+//   - ```tex key=value
+//
+// A '$' which does not open valid inline LaTeX is just markdown, as are \( and
+// \[ without a matching \) or \]. Environments must begin a line (indented by
+// at most three spaces), or the content of a list item or block quote, and be
+// in the Lexer's list of environments.
+//
+// Code follows CommonMark: a run of backticks opens a code span only if closed
+// by a run of the same length, and fenced code blocks must begin a line.
+func (l *Lexer) checkType(off int) ChunkType {
+	src := l.src[off:]
 
 	switch {
-	case peek[0] == '$':
-		peek, err = md.Peek(2)
-		// Dollar sign at the very end of the document
-		if err != nil {
-			return MD, err
+	case off == 0 && isFront(src):
+		return FRONT
+	case strings.HasPrefix(src, "$$"):
+		return BLOCK
+	case strings.HasPrefix(src, "$"):
+		if inlineLen(src) > 0 {
+			return INLINE
+		}
+
+		return MD
+	case strings.HasPrefix(src, `\(`):
+		if l.delimitedLen(off, `\(`, `\)`, true) > 0 {
+			return INLINE
+		}
+
+		return MD
+	case strings.HasPrefix(src, `\[`):
+		if l.delimitedLen(off, `\[`, `\]`, false) > 0 {
+			return BLOCK
+		}
+
+		return MD
+	case strings.HasPrefix(src, `\begin{`):
+		if l.envs[envName(src)] && l.blockStart(off) && envLen(src) > 0 {
+			return ENV
+		}
+
+		return MD
+	case (strings.HasPrefix(src, "`") || strings.HasPrefix(src, "~")) && l.lineStart(off) && fenceLen(src) > 0:
+		info, _, _ := strings.Cut(src[fenceLen(src):], "\n")
+		if strings.TrimSpace(info) == MacrosInfo {
+			return MACROS
 		}
 
-		if string(peek) == "$$" {
-			return BLOCK, nil
+		if _, ok := texAttrs(info); ok {
+			return BLOCK
 		}
 
-		return INLINE, nil
+		return CODE
+	case strings.HasPrefix(src, "`"):
+		if codeSpanLen(src) > 0 {
+			return FENCE
+		}
 
-	case peek[0] == '`':
-		// If we reach EOF here, the only two possibilities are an unterminated '`'
-		// or a closed fence with no content ('``')
-		peek, err = md.Peek(3)
-		if err != nil {
-			return FENCE, err
+		return MD
+	case strings.HasPrefix(src, " ") || strings.HasPrefix(src, "\t"):
+		if l.indentedCode(off) {
+			return CODE
 		}
 
-		if string(peek) == "```" {
-			return CODE, nil
+		return MD
+	default:
+		return MD
+	}
+}
+
+// Measure the indentation of [line] in columns, with tab stops of 4.
+func indentation(line string) int {
+	cols := 0
+
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			cols++
+		case '\t':
+			cols += 4 - cols%4
+		default:
+			return cols
 		}
+	}
+
+	return cols
+}
 
-		return FENCE, nil
+// Reports whether [line] begins a list item, e.g. "- item" or "1. item".
+func listItem(line string) bool {
+	line = strings.TrimLeft(line, " \t")
+
+	i := 0
+	for i < len(line) && i < 9 && isDigit(line[i]) {
+		i++
+	}
+
+	switch {
+	case i == 0 && len(line) > 0 && strings.IndexByte("-*+", line[0]) >= 0:
+		i = 1
+	case i > 0 && i < len(line) && (line[i] == '.' || line[i] == ')'):
+		i++
 	default:
-		return MD, nil
+		return false
+	}
+
+	return i == len(line) || line[i] == ' ' || line[i] == '\t'
+}
+
+// Reports whether an indented code block begins at [off]. Indented code can't
+// interrupt a paragraph, so must follow a blank line. The Lexer doesn't track
+// container blocks, so indented content following a list item (or anything
+// else indented) is assumed to continue that item rather than begin code.
+func (l *Lexer) indentedCode(off int) bool {
+	if off > 0 && l.src[off-1] != '\n' {
+		return false
+	}
+
+	line, _, _ := strings.Cut(l.src[off:], "\n")
+	if strings.TrimSpace(line) == "" || indentation(line) < 4 {
+		return false
+	}
+
+	before := strings.TrimRight(l.src[:off], " \t\r\n")
+	if before == "" {
+		return true
+	}
+
+	if strings.Count(l.src[len(before):off], "\n") < 2 {
+		return false
+	}
+
+	prev := before[strings.LastIndexByte(before, '\n')+1:]
+
+	return indentation(prev) == 0 && !listItem(prev)
+}
+
+// Count the repetitions of [c] at the start of [src].
+func runLen(src string, c byte) int {
+	n := 0
+	for n < len(src) && src[n] == c {
+		n++
+	}
+
+	return n
+}
+
+// Determine the length of the code span at the start of [src], including its
+// backticks, or 0 if the opening run of backticks is never closed by a run of
+// exactly the same length. Code spans may not span a blank line.
+func codeSpanLen(src string) int {
+	n := runLen(src, '`')
+
+	for i := n; i < len(src); {
+		switch {
+		case src[i] == '`':
+			m := runLen(src[i:], '`')
+			if m == n {
+				return i + m
+			}
+
+			i += m
+		case blankLine(src, i):
+			return 0
+		default:
+			i++
+		}
+	}
+
+	return 0
+}
+
+// Determine the length of the fence opening a code block at the start of [src],
+// or 0 if [src] doesn't begin with one. A fence is at least three backticks or
+// tildes; the info string of a backtick fence may not contain backticks.
+func fenceLen(src string) int {
+	if len(src) == 0 || (src[0] != '`' && src[0] != '~') {
+		return 0
+	}
+
+	n := runLen(src, src[0])
+	if n < 3 {
+		return 0
+	}
+
+	if info, _, _ := strings.Cut(src[n:], "\n"); src[0] == '`' && strings.ContainsRune(info, '`') {
+		return 0
 	}
+
+	return n
 }
 
-// Read until '$' or '`'
-func readMd(md *bufio.Reader) (Chunk, error) {
-	var b strings.Builder
+// Reports whether [off] begins a block: either it begins its line, or only the
+// markers of list items and block quotes (e.g. "1. " or "> - ") precede it. The
+// content of a container stays within it, e.g. LaTeX opening a list item is
+// rendered in place rather than ending the list.
+func (l *Lexer) blockStart(off int) bool {
+	if l.lineStart(off) {
+		return true
+	}
+
+	line := l.src[strings.LastIndexByte(l.src[:off], '\n')+1 : off]
+
+	// Indentation alone (e.g. of code) doesn't make a container.
+	if strings.TrimLeft(line, " ") == "" {
+		return false
+	}
 
 	for {
-		c, _, err := md.ReadRune()
-		if err != nil {
-			return Chunk{MD, b.String()}, err
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			return true
+		}
+
+		if strings.HasPrefix(line, ">") {
+			line = line[1:]
+
+			continue
+		}
+
+		marker, rest, ok := strings.Cut(line, " ")
+		if !ok || !listItem(marker) {
+			return false
+		}
+
+		line = rest
+	}
+}
+
+// Reports whether only indentation of up to three spaces precedes [off] on its
+// line.
+func (l *Lexer) lineStart(off int) bool {
+	for i := 0; i < 4; i++ {
+		if off == 0 || l.src[off-1] == '\n' {
+			return true
+		}
+
+		if l.src[off-1] != ' ' {
+			return false
+		}
+
+		off--
+	}
+
+	return false
+}
+
+// Read until the start of a chunk of any other type. Escaped characters (e.g.
+// \$) are left for the markdown renderer to unescape.
+func (l *Lexer) readMd(off int) (Chunk, int) {
+	src := l.src[off:]
+	i := 0
+
+	for i < len(src) {
+		c := src[i]
+
+		if i > 0 {
+			boundary := c == '$' || c == '`' || c == '~' || c == '\\' ||
+				((c == ' ' || c == '\t') && src[i-1] == '\n')
+
+			if boundary && l.checkType(off+i) != MD {
+				break
+			}
 		}
 
-		if c == '$' || c == '`' {
-			md.UnreadRune()
+		if c == '\\' && i+1 < len(src) && isPunct(src[i+1]) {
+			i += 2
 
-			return Chunk{MD, b.String()}, nil
+			continue
 		}
 
-		b.WriteRune(c)
+		// A run of backticks which doesn't open a code span is literal in its
+		// entirety. Its tail mustn't be mistaken for a shorter code span.
+		if c == '`' {
+			i += runLen(src[i:], '`')
+
+			continue
+		}
+
+		i++
 	}
+
+	return Chunk{T: MD, Content: src[:i]}, i
+}
+
+// Parse the attributes of a fenced LaTeX block from the [info] string of the
+// fence, e.g. "tex engine=lualatex". Reports false if [info] doesn't introduce a
+// LaTeX block; without attributes, a tex block is merely code.
+func texAttrs(info string) (map[string]string, bool) {
+	fields := strings.Fields(info)
+	if len(fields) < 2 || fields[0] != TexInfo {
+		return nil, false
+	}
+
+	attrs := make(map[string]string, len(fields)-1)
+
+	for _, f := range fields[1:] {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, false
+		}
+
+		attrs[k] = strings.Trim(v, `"'`)
+	}
+
+	return attrs, true
 }
 
 // Read until terminating '$$' or end of document. Anything after a '$$' is a
-// block.
+// block. Escaped dollar signs (\$) do not terminate the block.
 //
-// TODO: Consider supporting escaping dollar signs.
-func readBlock(tex *bufio.Reader) (Chunk, error) {
-	var b strings.Builder
+// Blocks delimited by \[ \] are display math rather than arbitrary TeX, so
+// their content is wrapped accordingly. Fenced blocks begin with the line
+// ending the info string, such that lines of content are numbered from the
+// fence as they are from $$.
+func readBlock(src string) (Chunk, int) {
+	if strings.HasPrefix(src, "`") || strings.HasPrefix(src, "~") {
+		n, body := fencedLen(src)
 
-	// Read off the first two '$$'
-	tex.Discard(2)
+		info, _, _ := strings.Cut(src[fenceLen(src):], "\n")
+		attrs, _ := texAttrs(info)
 
-	for {
-		c, _, err := tex.ReadRune()
-		if err != nil {
-			return Chunk{BLOCK, strings.TrimSuffix(b.String(), "$")}, err
+		start := min(fenceLen(src)+len(info), body)
+
+		return Chunk{T: BLOCK, Content: src[start:body], Attrs: attrs}, n
+	}
+
+	if strings.HasPrefix(src, `\[`) {
+		n := delimitedLen(src, `\[`, `\]`, false)
+
+		return Chunk{T: BLOCK, Content: "$\\displaystyle " + src[2:n-2] + "$"}, n
+	}
+
+	body := src[2:]
+
+	for i := 0; i < len(body); {
+		if body[i] == '\\' {
+			i += 2
+
+			continue
 		}
 
-		b.WriteRune(c)
+		if strings.HasPrefix(body[i:], "$$") {
+			return Chunk{T: BLOCK, Content: body[:i]}, i + 4
+		}
+
+		i++
+	}
+
+	return Chunk{T: BLOCK, Content: body}, len(src)
+}
+
+// Read valid Inline LaTeX delimited by either $ $ or \( \).
+func readInline(src string) (Chunk, int) {
+	if strings.HasPrefix(src, `\(`) {
+		n := delimitedLen(src, `\(`, `\)`, true)
+
+		return Chunk{T: INLINE, Content: src[2 : n-2]}, n
+	}
+
+	n := inlineLen(src)
+
+	return Chunk{T: INLINE, Content: src[1 : n-1]}, n
+}
 
-		peek, err := tex.Peek(1)
-		if err != nil {
-			return Chunk{BLOCK, strings.TrimSuffix(b.String(), "$")}, err
+// Extract the name of the environment begun at the start of [src], e.g. align*
+// for \begin{align*}.
+func envName(src string) string {
+	name, _, ok := strings.Cut(strings.TrimPrefix(src, `\begin{`), "}")
+	if !ok {
+		return ""
+	}
+
+	return name
+}
+
+// Determine the length of the environment begun at the start of [src], up to
+// and including the matching \end, or 0 if it is never closed. Environments of
+// the same name may be nested.
+func envLen(src string) int {
+	name := envName(src)
+	begin, end := `\begin{`+name+"}", `\end{`+name+"}"
+
+	depth := 0
+
+	for i := 0; i < len(src); {
+		switch {
+		case strings.HasPrefix(src[i:], begin):
+			depth++
+			i += len(begin)
+		case strings.HasPrefix(src[i:], end):
+			depth--
+			i += len(end)
+
+			if depth == 0 {
+				return i
+			}
+		case src[i] == '\\':
+			i += 2
+		default:
+			i++
 		}
+	}
+
+	return 0
+}
 
-		if c == '$' && peek[0] == '$' {
-			tex.Discard(1)
+// Read a bare LaTeX environment, delimiters and all.
+func readEnv(src string) (Chunk, int) {
+	n := envLen(src)
 
-			return Chunk{BLOCK, strings.TrimSuffix(b.String(), "$")}, err
+	return Chunk{T: ENV, Content: src[:n]}, n
+}
+
+// Determine the length of the frontmatter at the start of [src], including its
+// delimiters, or 0 if [src] doesn't begin with frontmatter. Frontmatter begins
+// with a line of --- and ends with a line of either --- or ...
+func frontLen(src string) int {
+	first, _, ok := strings.Cut(src, "\n")
+	if !ok || strings.TrimRight(first, " \t\r") != "---" {
+		return 0
+	}
+
+	for i := len(first) + 1; i < len(src); {
+		line, _, ok := strings.Cut(src[i:], "\n")
+		end := i + len(line)
+
+		if l := strings.TrimRight(line, " \t\r"); l == "---" || l == "..." {
+			if ok {
+				end++
+			}
+
+			return end
+		}
+
+		if !ok {
+			break
 		}
+
+		i = end + 1
 	}
+
+	return 0
+}
+
+// Read the frontmatter of the document, excluding its delimiters.
+func readFront(src string) (Chunk, int) {
+	n := frontLen(src)
+
+	start := strings.IndexByte(src, '\n') + 1
+	end := strings.LastIndex(strings.TrimRight(src[:n], "\n"), "\n") + 1
+
+	return Chunk{T: FRONT, Content: src[start:end]}, n
 }
 
-// Read valid Inline LaTeX or treat as Markdown.
-func readInline(tex *bufio.Reader) (Chunk, error) {
-	tex.Discard(1)
+// Reports whether [src] begins with valid frontmatter. A document may well
+// begin with a thematic break instead, so what doesn't parse is left to be
+// rendered as markdown.
+func isFront(src string) bool {
+	if frontLen(src) == 0 {
+		return false
+	}
+
+	c, _ := readFront(src)
+	if _, err := frontmatter.Parse(c.Content); err != nil {
+		logger.Error("Rendering invalid frontmatter as markdown: %s", err)
+
+		return false
+	}
 
-	content, err := tex.ReadString('$')
+	return true
+}
+
+// Read the LaTeX definitions within a fenced block of macros, excluding the
+// fences themselves.
+func readMacros(src string) (Chunk, int) {
+	n, body := fencedLen(src)
 
-	if err != nil && err != io.EOF {
-		return Chunk{}, err
+	start := strings.IndexByte(src, '\n') + 1
+	if start == 0 || start > body {
+		start = body
 	}
 
-	n := len(content)
-	if n > 1 {
-		if unicode.IsSpace(rune(content[n-2])) {
-			// Because we use ReadString() above, we cannot use UnreadRune()
-			tex.UnreadByte()
+	return Chunk{T: MACROS, Content: src[start:body]}, n
+}
+
+// Determine the length of the fenced code block at the start of [src], and the
+// offset of the line holding its closing fence. A block is closed by a fence of
+// the same character at least as long as the opening fence, alone on its line.
+// An unterminated block runs to the end of the document.
+func fencedLen(src string) (n, body int) {
+	c, open := src[0], fenceLen(src)
 
-			return Chunk{MD, strings.TrimSuffix("$"+content[:n-1], "$")}, err
+	for i := strings.IndexByte(src, '\n'); i >= 0; {
+		line, _, _ := strings.Cut(src[i+1:], "\n")
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent < 4 {
+			m := runLen(line[indent:], c)
+
+			if m >= open && strings.TrimSpace(line[indent+m:]) == "" {
+				return i + 1 + indent + m, i + 1
+			}
+		}
+
+		next := strings.IndexByte(src[i+1:], '\n')
+		if next < 0 {
+			break
 		}
 
-		return Chunk{INLINE, strings.TrimSuffix(content, "$")}, err
+		i += next + 1
 	}
 
-	return Chunk{MD, "$" + content}, nil
+	return len(src), len(src)
 }
 
-// Fenced code block delimited with ```
+// Read a code block, either fenced or indented, as markdown.
 //
 //	 Example
 //	   ```code
 //		  code here
 //		  ```
-func readCodeBlock(md *bufio.Reader) (Chunk, error) {
-	md.Discard(3)
-
-	block, err := md.ReadString('`')
-	if err != nil {
-		return Chunk{MD, "```" + block}, err
+func readCodeBlock(src string) (Chunk, int) {
+	if src[0] == ' ' || src[0] == '\t' {
+		return readIndentedCode(src)
 	}
 
-	// Read off the remaining backticks
-	md.Discard(2)
+	n, _ := fencedLen(src)
 
-	// Only need to append two backticks here, since ReadString includes one
-	return Chunk{MD, "```" + block + "``"}, nil
+	return Chunk{T: MD, Content: src[:n]}, n
 }
 
-// If a fence marker is found, all content until the matching delimiter will be
-// treated as markdown. If no terminated delimiter is found, read until the end
-// of the document.
-//
-//	`inline fence`
-func readFence(md *bufio.Reader) (Chunk, error) {
-	md.Discard(1)
+// Read an indented code block: every following line which is either blank or
+// indented by at least 4 columns. Trailing blank lines are left as markdown.
+func readIndentedCode(src string) (Chunk, int) {
+	n := 0
 
-	fence, err := md.ReadString('`')
-	if err != nil {
-		return Chunk{MD, fence}, err
+	for i := 0; i < len(src); {
+		line, _, _ := strings.Cut(src[i:], "\n")
+		end := i + len(line)
+
+		if strings.TrimSpace(line) != "" {
+			if indentation(line) < 4 {
+				break
+			}
+
+			n = end
+		}
+
+		i = end + 1
 	}
 
-	return Chunk{MD, "`" + fence}, nil
+	return Chunk{T: MD, Content: src[:n]}, n
+}
+
+// A code span is treated as markdown in its entirety, e.g.
+//
+//	`inline fence` or ``a ` within``
+func readFence(src string) (Chunk, int) {
+	n := codeSpanLen(src)
+
+	return Chunk{T: MD, Content: src[:n]}, n
 }
 
 // Check the first character to determine which type of content to read
-func lex(md *bufio.Reader) (Chunk, error) {
-	t, err := checkType(md)
-	if err != nil {
-		return Chunk{}, err
-	}
+func (l *Lexer) lex(off int) (Chunk, int) {
+	src := l.src[off:]
 
-	switch t {
+	switch l.checkType(off) {
 	case BLOCK:
-		return readBlock(md)
+		return readBlock(src)
 	case INLINE:
-		return readInline(md)
+		return readInline(src)
 	case FENCE:
-		return readFence(md)
+		return readFence(src)
 	case CODE:
-		return readCodeBlock(md)
+		return readCodeBlock(src)
+	case ENV:
+		return readEnv(src)
+	case FRONT:
+		return readFront(src)
+	case MACROS:
+		return readMacros(src)
 	default:
-		return readMd(md)
+		return l.readMd(off)
+	}
+}
+
+// Lexer splits a markdown document into chunks, tracking the position of each
+// within the source.
+//
+// The document is lexed in memory, as determining where some chunks end (e.g.
+// inline LaTeX) requires arbitrary lookahead.
+type Lexer struct {
+	src  string
+	off  int             // Offset of the next chunk within src.
+	pos  Position        // Position of the next chunk.
+	err  error           // Error encountered reading the document.
+	envs map[string]bool // Environments recognised outside of math delimiters.
+
+	// Spans in which LaTeX opened is known never to be closed, by closing
+	// delimiter.
+	unclosed map[string]span
+}
+
+// NewLexer creates a Lexer reading a markdown document from [md]. The Lexer
+// recognises the DefaultEnvironments.
+func NewLexer(md io.Reader) *Lexer {
+	src, err := io.ReadAll(md)
+
+	l := &Lexer{src: string(src), pos: Position{Offset: 0, Line: 1, Column: 1}, err: err, unclosed: make(map[string]span)}
+	l.SetEnvironments(DefaultEnvironments)
+
+	return l
+}
+
+// SetEnvironments replaces the LaTeX environments which the Lexer recognises
+// outside of math delimiters with [names].
+func (l *Lexer) SetEnvironments(names []string) {
+	l.envs = make(map[string]bool, len(names))
+
+	for _, name := range names {
+		l.envs[name] = true
 	}
 }
 
-// ChunkDoc lexs markdown content into three distinct types of "chunks":
+// Next lexs the next chunk of markdown content. Chunks are one of six distinct
+// types:
 //
 //   - Markdown
 //   - Inline LaTeX
 //   - Block LaTeX
+//   - LaTeX environments
+//   - LaTeX macros
+//   - Frontmatter
 //
 // Individual LaTeX chunks will include the contents of a properly formed block
 // or inline LaTeX, e.g:
@@ -245,7 +866,56 @@ func lex(md *bufio.Reader) (Chunk, error) {
 //
 // $\int_1^x x \; dx$
 //
+// LaTeX may equivalently be delimited by \( \) (inline) and \[ \] (display).
+// Environments such as \begin{align} \end{align} need no delimiters at all.
+//
 // While markdown blocks are contiguous blocks of non-LaTeX content.
+//
+// Next returns io.EOF once the document is exhausted.
+func (l *Lexer) Next() (Chunk, error) {
+	if l.err != nil {
+		return Chunk{}, l.err
+	}
+
+	if l.off >= len(l.src) {
+		return Chunk{}, io.EOF
+	}
+
+	c, n := l.lex(l.off)
+
+	c.Start = l.pos
+
+	l.pos = l.pos.advance(l.src[l.off : l.off+n])
+	l.off += n
+
+	c.End = l.pos
+
+	return c, nil
+}
+
+var (
+	docsMu sync.Mutex
+	docs   = make(map[*bufio.Reader]*Lexer) // Lexers of documents read by ChunkDoc.
+)
+
+// ChunkDoc lexs the next chunk of the markdown document read by [md], as does
+// Lexer.Next. It is kept for callers predating the Lexer: a document must be
+// read until ChunkDoc returns an error (e.g. io.EOF), or the Lexer reading it
+// is retained.
 func ChunkDoc(md *bufio.Reader) (Chunk, error) {
-	return lex(md)
+	docsMu.Lock()
+	defer docsMu.Unlock()
+
+	l, ok := docs[md]
+	if !ok {
+		l = NewLexer(md)
+		docs[md] = l
+	}
+
+	c, err := l.Next()
+	if err != nil {
+		delete(docs, md)
+	}
+
+	return c, err
 }
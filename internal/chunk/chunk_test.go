@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func chunkEq(a, b Chunk) bool {
@@ -93,7 +94,7 @@ func TestChunkDoc(t *testing.T) {
 			t.Errorf("Failed to parse block: %s", err)
 		}
 
-		expected := Chunk{BLOCK, "x+y=z"}
+		expected := Chunk{T: BLOCK, Content: "x+y=z"}
 
 		cmpChunk(expected, c, t)
 	})
@@ -102,21 +103,21 @@ func TestChunkDoc(t *testing.T) {
 		files, _ := filepath.Glob("testdata/block-*")
 
 		expected := map[string][]Chunk{
-			"block-1.md": []Chunk{Chunk{BLOCK, ""}},
-			"block-2.md": []Chunk{Chunk{BLOCK, "a + b = c"}},
-			"block-3.md": []Chunk{Chunk{BLOCK, "\n\\begin{tabular}{c c c}\na & b & c \\\\\n\\end{tabular}\n"}},
+			"block-1.md": []Chunk{Chunk{T: BLOCK, Content: ""}},
+			"block-2.md": []Chunk{Chunk{T: BLOCK, Content: "a + b = c"}},
+			"block-3.md": []Chunk{Chunk{T: BLOCK, Content: "\n\\begin{tabular}{c c c}\na & b & c \\\\\n\\end{tabular}\n"}},
 			"block-4.md": []Chunk{
-				Chunk{BLOCK, "a + b = c"},
-				Chunk{MD, "\n"},
-				Chunk{BLOCK, "\n\\begin{tabular}{c c c}\na & b & c\n\\end{tabular}\n"},
+				Chunk{T: BLOCK, Content: "a + b = c"},
+				Chunk{T: MD, Content: "\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{tabular}{c c c}\na & b & c\n\\end{tabular}\n"},
 			},
 			"block-5.md": []Chunk{
-				Chunk{BLOCK, "\n\\begin{tabular}{c c c}\na & b & c\n\\end{tabular}\n"},
-				Chunk{MD, "\n\n"},
-				Chunk{BLOCK, "\n\\begin{equation}\n$x + y = z$\n\\end{equation}\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{tabular}{c c c}\na & b & c\n\\end{tabular}\n"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{equation}\n$x + y = z$\n\\end{equation}\n"},
 			},
 			"block-6.md": []Chunk{
-				Chunk{BLOCK, "\n```\nprint(f'${var}')\n```\n"},
+				Chunk{T: BLOCK, Content: "\n```\nprint(f'${var}')\n```\n"},
 			},
 		}
 
@@ -127,20 +128,16 @@ func TestChunkDoc(t *testing.T) {
 		files, _ := filepath.Glob("testdata/malformed-*")
 
 		expected := map[string][]Chunk{
-			"malformed-1.md": []Chunk{Chunk{BLOCK, "\n\\begin{equation}\n\nMore text\n"}},
-			"malformed-2.md": []Chunk{Chunk{BLOCK, "\\begin{equation}x + y = z\\end{equation}$abc\n"}},
+			"malformed-1.md": []Chunk{Chunk{T: BLOCK, Content: "\n\\begin{equation}\n\nMore text\n"}},
+			"malformed-2.md": []Chunk{Chunk{T: BLOCK, Content: "\\begin{equation}x + y = z\\end{equation}$abc\n"}},
 			"malformed-3.md": []Chunk{
-				Chunk{MD, "$x + y = z "},
-				Chunk{MD, "$\n"},
-				Chunk{MD, "$ "},
-				Chunk{INLINE, "100"},
-				Chunk{MD, "\n"},
-				Chunk{MD, "$x = -b \\pm \\frac {\\sqrt{b^2 - 4ac}} {2a}\n"},
-				Chunk{MD, "$\n"},
+				Chunk{T: MD, Content: "$x + y = z $\n$ "},
+				Chunk{T: INLINE, Content: "100"},
+				Chunk{T: MD, Content: "\n$x = -b \\pm \\frac {\\sqrt{b^2 - 4ac}} {2a}\n$\n"},
 			},
 			"malformed-4.md": []Chunk{
-				Chunk{MD, "$100\n\n"},
-				Chunk{BLOCK, "\n10\n"},
+				Chunk{T: MD, Content: "$100\n\n"},
+				Chunk{T: BLOCK, Content: "\n10\n"},
 			},
 		}
 
@@ -151,20 +148,207 @@ func TestChunkDoc(t *testing.T) {
 		files, _ := filepath.Glob("testdata/inline-*")
 
 		expected := map[string][]Chunk{
-			"inline-1.md": []Chunk{Chunk{INLINE, "x + y = 10"}},
+			"inline-1.md": []Chunk{Chunk{T: INLINE, Content: "x + y = 10"}},
+			"inline-2.md": []Chunk{
+				Chunk{T: MD, Content: "I got $100 in my pocket, and spent $20 on "},
+				Chunk{T: INLINE, Content: "n"},
+				Chunk{T: MD, Content: " apples at $5 each.\n\nThe price rose from $3 to $4, or "},
+				Chunk{T: INLINE, Content: "\\frac 4 3"},
+				Chunk{T: MD, Content: " times.\n"},
+			},
+			"inline-3.md": []Chunk{
+				Chunk{T: MD, Content: "Prices start at $5 and\n\nend at $10 (not math), $x$1 isn't either.\n"},
+			},
+		}
+
+		testFiles(files, expected, t)
+	})
+
+	t.Run("Escape", func(t *testing.T) {
+		files, _ := filepath.Glob("testdata/escape-*")
+
+		expected := map[string][]Chunk{
+			"escape-1.md": []Chunk{
+				Chunk{T: MD, Content: "It costs \\$5, or \\$4 with a coupon. "},
+				Chunk{T: INLINE, Content: "\\$x = y\\$"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: BLOCK, Content: "\n\\text{\\$\\$ and $\\$$}\n"},
+				Chunk{T: MD, Content: "\n\n\\\\"},
+				Chunk{T: INLINE, Content: "y"},
+				Chunk{T: MD, Content: "\n"},
+			},
+		}
+
+		testFiles(files, expected, t)
+	})
+
+	t.Run("Delimiters", func(t *testing.T) {
+		files, _ := filepath.Glob("testdata/delim-*")
+
+		expected := map[string][]Chunk{
+			"delim-1.md": []Chunk{
+				Chunk{T: MD, Content: "Inline "},
+				Chunk{T: INLINE, Content: "x^2"},
+				Chunk{T: MD, Content: " and display:\n\n"},
+				Chunk{T: BLOCK, Content: "$\\displaystyle \n\\int_0^1 x \\, dx\n$"},
+				Chunk{T: MD, Content: "\n\nEscaped \\\\(not math) and unterminated \\( here.\n\n"},
+				Chunk{T: MD, Content: "`\\(code\\)`"},
+				Chunk{T: MD, Content: " and\n\n"},
+				Chunk{T: MD, Content: "```\n\\[x\\]\n```"},
+				Chunk{T: MD, Content: "\n"},
+			},
+			// Inline LaTeX cannot span paragraphs, but blocks can.
+			"delim-2.md": []Chunk{
+				Chunk{T: MD, Content: "\\(a\n\nb\\) "},
+				Chunk{T: BLOCK, Content: "$\\displaystyle a\n\nb$"},
+				Chunk{T: MD, Content: "\n"},
+			},
+		}
+
+		testFiles(files, expected, t)
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		files, _ := filepath.Glob("testdata/env-*")
+
+		expected := map[string][]Chunk{
+			"env-1.md": []Chunk{
+				Chunk{T: MD, Content: "Some text\n\n"},
+				Chunk{T: ENV, Content: "\\begin{align}\nx &= 1 \\\\\n\\begin{align}y\\end{align}\n\\end{align}"},
+				Chunk{T: MD, Content: "\n\n  "},
+				Chunk{T: ENV, Content: "\\begin{tikzpicture}\n\\draw (0,0) -- (1,1);\n\\end{tikzpicture}"},
+				Chunk{T: MD, Content: "\nInline \\begin{align}not a block\\end{align} text.\n"},
+			},
+			// Unterminated, unknown and indented code environments are markdown.
+			"env-2.md": []Chunk{
+				Chunk{T: MD, Content: "\\begin{align}\nx = 1\n\n\\begin{document}x\\end{document}\n    \\begin{equation}indented\\end{equation}\n"},
+			},
+			// Environments may open list items and block quotes.
+			"env-3.md": []Chunk{
+				Chunk{T: MD, Content: "1. "},
+				Chunk{T: ENV, Content: "\\begin{align}\n   x &= 1\n   \\end{align}"},
+				Chunk{T: MD, Content: "\n2. Second\n   "},
+				Chunk{T: ENV, Content: "\\begin{align}y\\end{align}"},
+				Chunk{T: MD, Content: "\n\n> - "},
+				Chunk{T: ENV, Content: "\\begin{align}z\\end{align}"},
+				Chunk{T: MD, Content: "\n"},
+			},
 		}
 
 		testFiles(files, expected, t)
 	})
 
+	t.Run("Frontmatter", func(t *testing.T) {
+		files, _ := filepath.Glob("testdata/front-*")
+
+		expected := map[string][]Chunk{
+			"front-1.md": []Chunk{
+				Chunk{T: FRONT, Content: "packages: [physics]\n"},
+				Chunk{T: MD, Content: "# Title "},
+				Chunk{T: INLINE, Content: "x"},
+				Chunk{T: MD, Content: "\n\n---\n\nnot: frontmatter\n---\n"},
+			},
+			// Without a closing delimiter, --- is just a thematic break.
+			"front-2.md": []Chunk{
+				Chunk{T: MD, Content: "---\n\nA thematic break "},
+				Chunk{T: INLINE, Content: "y"},
+			},
+			// Nor is it frontmatter unless what it delimits parses.
+			"front-3.md": []Chunk{
+				Chunk{T: MD, Content: "---\nSome prose, set between rules "},
+				Chunk{T: INLINE, Content: "z"},
+				Chunk{T: MD, Content: ".\n---\n"},
+			},
+			"front-4.md": []Chunk{
+				Chunk{T: MD, Content: "---\npackages: [physics\n---\n"},
+				Chunk{T: INLINE, Content: "w"},
+			},
+		}
+
+		testFiles(files, expected, t)
+	})
+
+	t.Run("Macros", func(t *testing.T) {
+		files, _ := filepath.Glob("testdata/macros-*")
+
+		expected := map[string][]Chunk{
+			"macros-1.md": []Chunk{
+				Chunk{T: INLINE, Content: "a"},
+				Chunk{T: MD, Content: "\n"},
+				Chunk{T: MACROS, Content: "\\newcommand{\\R}{\\mathbb{R}}\n"},
+				Chunk{T: MD, Content: "\n"},
+				Chunk{T: INLINE, Content: "\\R"},
+				Chunk{T: MD, Content: "\n  "},
+				Chunk{T: MACROS, Content: "\\def\\N{\\mathbb{N}}\n"},
+				Chunk{T: MD, Content: "\n"},
+				Chunk{T: MD, Content: "```latex-macrosx\n$b$\n```"},
+			},
+			"macros-2.md": []Chunk{
+				Chunk{T: MACROS, Content: "\\def\\x{y}\n"},
+			},
+		}
+
+		testFiles(files, expected, t)
+	})
+
+	t.Run("TexFence", func(t *testing.T) {
+		files, _ := filepath.Glob("testdata/tex-*")
+
+		expected := map[string][]Chunk{
+			// Without attributes, tex blocks are just code.
+			"tex-1.md": []Chunk{
+				Chunk{T: BLOCK, Content: "\n\\begin{tikzpicture}\n\\end{tikzpicture}\n"},
+				Chunk{T: MD, Content: "\n"},
+				Chunk{T: MD, Content: "```tex\n$x$\n```"},
+			},
+		}
+
+		testFiles(files, expected, t)
+
+		c, _ := NewLexer(strings.NewReader("~~~tex engine=lualatex class='a b'\n~~~")).Next()
+		if c.T != MD {
+			t.Errorf("Expected malformed attributes to be markdown, got %s", c)
+		}
+
+		c, _ = NewLexer(strings.NewReader("~~~tex engine=lualatex\n~~~")).Next()
+		if c.T != BLOCK || len(c.Attrs) != 1 || c.Attrs["engine"] != "lualatex" {
+			t.Errorf("Unexpected attributes: %v", c.Attrs)
+		}
+	})
+
 	t.Run("Fence", func(t *testing.T) {
 		files, _ := filepath.Glob("testdata/fence-*")
 
 		expected := map[string][]Chunk{
-			"fence-1.md": []Chunk{Chunk{MD, "```python\ndef fib(n):\n    if n <= 1:\n        return 1\n\n    return fib(n-1) + fib(n-2)\n```"}},
-			"fence-2.md": []Chunk{Chunk{MD, "`inline code block`"}},
-			"fence-3.md": []Chunk{Chunk{MD, "```\n$$\\begin{equation}a + b = c\\end{equation}$$\n```"}},
-			"fence-4.md": []Chunk{Chunk{MD, "`$x + y = z$`"}},
+			"fence-1.md": []Chunk{Chunk{T: MD, Content: "```python\ndef fib(n):\n    if n <= 1:\n        return 1\n\n    return fib(n-1) + fib(n-2)\n```"}},
+			"fence-2.md": []Chunk{Chunk{T: MD, Content: "`inline code block`"}},
+			"fence-3.md": []Chunk{Chunk{T: MD, Content: "```\n$$\\begin{equation}a + b = c\\end{equation}$$\n```"}},
+			"fence-4.md": []Chunk{Chunk{T: MD, Content: "`$x + y = z$`"}},
+			// Fences close only on a fence of the same character at least as long.
+			"fence-5.md": []Chunk{Chunk{T: MD, Content: "````md\n```\n$x$\n```\n````"}},
+			"fence-6.md": []Chunk{
+				Chunk{T: MD, Content: "~~~\n$$x$$\n~~~~"},
+				Chunk{T: MD, Content: "\ntext "},
+				Chunk{T: INLINE, Content: "y"},
+			},
+			// Code spans close only on a run of backticks of the same length.
+			"fence-7.md": []Chunk{
+				Chunk{T: MD, Content: "Span "},
+				Chunk{T: MD, Content: "``a ` $b$``"},
+				Chunk{T: MD, Content: " and `` unmatched `"},
+				Chunk{T: INLINE, Content: "c"},
+			},
+			// Indented code can't interrupt a paragraph or continue a list item.
+			"fence-8.md": []Chunk{
+				Chunk{T: MD, Content: "Paragraph\n\n"},
+				Chunk{T: MD, Content: "    $x$ code\n\n    more $y$"},
+				Chunk{T: MD, Content: "\n\nafter "},
+				Chunk{T: INLINE, Content: "z"},
+				Chunk{T: MD, Content: "\n    "},
+				Chunk{T: INLINE, Content: "v"},
+				Chunk{T: MD, Content: "\n- item\n\n    "},
+				Chunk{T: INLINE, Content: "w"},
+			},
 		}
 
 		testFiles(files, expected, t)
@@ -175,9 +359,8 @@ func TestChunkDoc(t *testing.T) {
 		b := bufio.NewReader(source)
 
 		expected := []Chunk{
-			Chunk{MD, "## Subheader abc "},
-			Chunk{MD, "$100 "},
-			Chunk{INLINE, "abcdefg"},
+			Chunk{T: MD, Content: "## Subheader abc $100 "},
+			Chunk{T: INLINE, Content: "abcdefg"},
 		}
 
 		for _, e := range expected {
@@ -198,42 +381,124 @@ func TestChunkDoc(t *testing.T) {
 
 		expected := map[string][]Chunk{
 			"hetero-1.md": []Chunk{
-				Chunk{MD, "# Heading\n\nSome text here\n\n## SubHeading\n\n"},
-				Chunk{INLINE, "x + y = z"},
-				Chunk{MD, "\n\n### Pythagorean equation: "},
-				Chunk{INLINE, "x^2 + y^2 = z^2"},
-				Chunk{MD, "\n\nSome notes on the Pythagorean Theorem.\n\n"},
-				Chunk{BLOCK, "\n\\begin{equation}\nE_n(x) = \\frac 1 {n!} \\int_1^x (x - t)^n f^{(n+1)}(t) \\; dt\n\\end{equation}\n"},
-				Chunk{MD, "\n\n"},
-				Chunk{BLOCK, "\n\\begin{tabular}{c c c}\na & b & c \\\\\nd & e & f\n\\end{tabular}\n"},
+				Chunk{T: MD, Content: "# Heading\n\nSome text here\n\n## SubHeading\n\n"},
+				Chunk{T: INLINE, Content: "x + y = z"},
+				Chunk{T: MD, Content: "\n\n### Pythagorean equation: "},
+				Chunk{T: INLINE, Content: "x^2 + y^2 = z^2"},
+				Chunk{T: MD, Content: "\n\nSome notes on the Pythagorean Theorem.\n\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{equation}\nE_n(x) = \\frac 1 {n!} \\int_1^x (x - t)^n f^{(n+1)}(t) \\; dt\n\\end{equation}\n"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{tabular}{c c c}\na & b & c \\\\\nd & e & f\n\\end{tabular}\n"},
 			},
 			"hetero-2.md": []Chunk{
-				Chunk{MD, "# Heading 1\n\n"},
-				Chunk{BLOCK, "\nx + y = z\n"},
-				Chunk{MD, "\n\n## Subheading 1\n\n"},
-				Chunk{BLOCK, "\n\\begin{tabular}{c c c}\na & b & c \\\\\nd & e & f\n\\end{tabular}\n"},
-				Chunk{MD, "\n\n## Subheading 2\n\nHere is some text. "},
-				Chunk{MD, "$100 is nothing to me, man \n\n"},
-				Chunk{BLOCK, "\n$P_\\omega={n_\\omega\\over 2}\\hbar\\omega\\,{1+R\\over 1-v^2}\\int\\limits_{-1}^{1}dx\\,(x-v)|x-v|,$\n"},
-				Chunk{MD, "\n\n"},
-				Chunk{BLOCK, "\n\\begin{tabular}{c c c}\ng & h & i \\\\\nj & k & l\n\\end{tabular}\n"},
-				Chunk{MD, "\n\n"},
-				Chunk{MD, "```python\n# This shouldn't be sent to the TeX server:\n'''\n$$\nx^2 + y^2 = z^2\n$$\n'''\n```"},
+				Chunk{T: MD, Content: "# Heading 1\n\n"},
+				Chunk{T: BLOCK, Content: "\nx + y = z\n"},
+				Chunk{T: MD, Content: "\n\n## Subheading 1\n\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{tabular}{c c c}\na & b & c \\\\\nd & e & f\n\\end{tabular}\n"},
+				Chunk{T: MD, Content: "\n\n## Subheading 2\n\nHere is some text. $100 is nothing to me, man \n\n"},
+				Chunk{T: BLOCK, Content: "\n$P_\\omega={n_\\omega\\over 2}\\hbar\\omega\\,{1+R\\over 1-v^2}\\int\\limits_{-1}^{1}dx\\,(x-v)|x-v|,$\n"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: BLOCK, Content: "\n\\begin{tabular}{c c c}\ng & h & i \\\\\nj & k & l\n\\end{tabular}\n"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: MD, Content: "```python\n# This shouldn't be sent to the TeX server:\n'''\n$$\nx^2 + y^2 = z^2\n$$\n'''\n```"},
 			},
 			"hetero-3.md": []Chunk{
-				Chunk{MD, "# Heading 1\n\n"},
-				Chunk{BLOCK, "x + y = z"},
-				Chunk{MD, "\n\n## Subheading 1\n\n"},
-				Chunk{BLOCK, "\\begin{tabular}{c c c}\na & b & c \\\\\nd & e & f\n\\end{tabular}"},
-				Chunk{MD, "\n\n## Subheading 2\n\nHere is some text.\n\n"},
-				Chunk{BLOCK, "\n$P_\\omega={n_\\omega\\over 2}\\hbar\\omega\\,{1+R\\over 1-v^2}\\int\\limits_{-1}^{1}dx\\,(x-v)|x-v|,$\n"},
-				Chunk{MD, "\n\n"},
-				Chunk{MD, "```python\n# This shouldn't be sent to the TeX server:\n'''\n$$\nx^2 + y^2 = z^2\n$$\n'''\n```"},
-				Chunk{MD, "\n\n"},
-				Chunk{INLINE, "\\int_1^x \\frac 1 x \\; dx"},
+				Chunk{T: MD, Content: "# Heading 1\n\n"},
+				Chunk{T: BLOCK, Content: "x + y = z"},
+				Chunk{T: MD, Content: "\n\n## Subheading 1\n\n"},
+				Chunk{T: BLOCK, Content: "\\begin{tabular}{c c c}\na & b & c \\\\\nd & e & f\n\\end{tabular}"},
+				Chunk{T: MD, Content: "\n\n## Subheading 2\n\nHere is some text.\n\n"},
+				Chunk{T: BLOCK, Content: "\n$P_\\omega={n_\\omega\\over 2}\\hbar\\omega\\,{1+R\\over 1-v^2}\\int\\limits_{-1}^{1}dx\\,(x-v)|x-v|,$\n"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: MD, Content: "```python\n# This shouldn't be sent to the TeX server:\n'''\n$$\nx^2 + y^2 = z^2\n$$\n'''\n```"},
+				Chunk{T: MD, Content: "\n\n"},
+				Chunk{T: INLINE, Content: "\\int_1^x \\frac 1 x \\; dx"},
 			},
 		}
 
 		testFiles(files, expected, t)
 	})
+
+	t.Run("Unbalanced", func(t *testing.T) {
+		// Unclosed delimiters mustn't each search the rest of the document.
+		source := strings.Repeat(`\[ a `, 20000) + strings.Repeat(`\( a `, 20000)
+
+		start := time.Now()
+
+		lx := NewLexer(strings.NewReader(source))
+
+		var md strings.Builder
+		for {
+			c, err := lx.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.T != MD {
+				t.Fatalf("Expected only Markdown, got %s", c)
+			}
+
+			md.WriteString(c.Content)
+		}
+
+		if md.String() != source {
+			t.Error("Expected the Markdown to cover the document")
+		}
+
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Lexing took %v", d)
+		}
+	})
+}
+
+func TestPosition(t *testing.T) {
+	t.Run("Heterogeneous", func(t *testing.T) {
+		source := "# Heading\n\nTotal: $x + y$ ✓\n\n$$\na + b = c\n$$\n`$code$`"
+
+		expected := []struct {
+			T          ChunkType
+			Start, End Position
+		}{
+			{MD, Position{0, 1, 1}, Position{18, 3, 8}},
+			{INLINE, Position{18, 3, 8}, Position{25, 3, 15}},
+			{MD, Position{25, 3, 15}, Position{31, 5, 1}},
+			{BLOCK, Position{31, 5, 1}, Position{46, 7, 3}},
+			{MD, Position{46, 7, 3}, Position{47, 8, 1}},
+			{MD, Position{47, 8, 1}, Position{55, 8, 9}},
+		}
+
+		lx := NewLexer(strings.NewReader(source))
+
+		for _, e := range expected {
+			c, err := lx.Next()
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+
+			if c.T != e.T || c.Start != e.Start || c.End != e.End {
+				t.Errorf("Expected %s [%v, %v), got %s [%v, %v)", e.T, e.Start, e.End, c.T, c.Start, c.End)
+			}
+
+			if source[c.Start.Offset:c.End.Offset] == "" {
+				t.Errorf("Empty span for chunk %s", c)
+			}
+		}
+	})
+
+	t.Run("Literal", func(t *testing.T) {
+		// A '$' which doesn't begin inline LaTeX is part of the Markdown.
+		lx := NewLexer(strings.NewReader("$100 $x$"))
+
+		c, _ := lx.Next()
+		if c.T != MD || c.End != (Position{5, 1, 6}) {
+			t.Errorf("Expected Markdown ending at 1:6, got %s ending at %v", c.T, c.End)
+		}
+
+		c, _ = lx.Next()
+		if c.T != INLINE || c.Start != (Position{5, 1, 6}) {
+			t.Errorf("Expected inline LaTeX starting at 1:6, got %s starting at %v", c.T, c.Start)
+		}
+	})
 }
@@ -0,0 +1,36 @@
+package chunk
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Position is a location within a source document.
+type Position struct {
+	Offset int // Offset is the byte offset from the start of the document, starting at 0.
+	Line   int // Line is the line number, starting at 1.
+	Column int // Column is the number of runes from the start of the line, starting at 1.
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Determine the Position following [s], which begins at [p].
+func (p Position) advance(s string) Position {
+	for len(s) > 0 {
+		c, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+
+		p.Offset += size
+
+		if c == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+
+	return p
+}
@@ -0,0 +1,303 @@
+// package frontmatter parses the metadata at the start of a markdown document.
+//
+// Frontmatter is written in YAML, of which only the subset needed for document
+// metadata is supported:
+//
+//	packages: [physics, tikz-cd]   # or one "- item" per line
+//	preamble: |
+//	  \newcommand{\R}{\mathbb{R}}
+//
+// Unknown keys are ignored, along with their values, which may be anything
+// (e.g. nested mappings) so long as they are indented beneath the key.
+package frontmatter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Frontmatter is the metadata of a single document.
+type Frontmatter struct {
+	Packages []string // Packages lists LaTeX packages used by the document, e.g. physics.
+	Preamble string   // Preamble is LaTeX to include in the preamble of the document's formulas.
+}
+
+// TexPreamble returns the LaTeX preamble declared by the frontmatter, loading
+// each of its packages.
+func (f Frontmatter) TexPreamble() string {
+	var b strings.Builder
+
+	for _, pkg := range f.Packages {
+		fmt.Fprintf(&b, "\\usepackage{%s}\n", pkg)
+	}
+
+	b.WriteString(f.Preamble)
+
+	return b.String()
+}
+
+// entry is the value of a top level key, as written: the rest of the line of
+// the key and the lines continuing it, i.e. those which are indented, blank
+// or items of a block sequence.
+type entry struct {
+	line  int      // Line of the key, for errors.
+	rest  string   // The rest of the line of the key, less any comment.
+	block []string // Lines continuing the value.
+}
+
+func errorf(line int, format string, args ...any) error {
+	return fmt.Errorf("frontmatter: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// Parse the frontmatter [src], excluding its delimiters.
+func Parse(src string) (Frontmatter, error) {
+	var f Frontmatter
+
+	entries, err := parse(src)
+	if err != nil {
+		return f, err
+	}
+
+	if e, ok := entries["packages"]; ok {
+		if f.Packages, err = e.list(); err != nil {
+			return f, err
+		}
+	}
+
+	if e, ok := entries["preamble"]; ok {
+		if f.Preamble, err = e.scalar(); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+// Remove a trailing comment from [s].
+func uncomment(s string) string {
+	if strings.HasPrefix(s, "#") {
+		return ""
+	}
+
+	if i := strings.Index(s, " #"); i >= 0 && !strings.ContainsAny(s[:i], `"'`) {
+		return s[:i]
+	}
+
+	return s
+}
+
+// Trim [line] of its indentation and any comment.
+func trim(line string) string {
+	return strings.TrimSpace(uncomment(strings.TrimSpace(line)))
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+
+	return s
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// Reports whether [line] continues the value of the key before it.
+func continues(line string) bool {
+	return isIndented(line) || trim(line) == "" || strings.HasPrefix(line, "-")
+}
+
+// Split the top level mapping of [src] into its entries.
+func parse(src string) (map[string]entry, error) {
+	entries := make(map[string]entry)
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if trim(line) == "" {
+			continue
+		}
+
+		if isIndented(line) {
+			return nil, errorf(i+1, "unexpected indentation")
+		}
+
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errorf(i+1, "expected key: value")
+		}
+
+		e := entry{line: i + 1, rest: trim(rest)}
+
+		for i+1 < len(lines) && continues(lines[i+1]) {
+			i++
+			e.block = append(e.block, lines[i])
+		}
+
+		entries[strings.TrimSpace(key)] = e
+	}
+
+	return entries, nil
+}
+
+// Interpret the entry as a list: a flow sequence (e.g. [a, b], which may span
+// several lines), a block sequence of "- item" lines, or a single scalar.
+func (e entry) list() ([]string, error) {
+	switch {
+	case strings.HasPrefix(e.rest, "["):
+		flow := e.rest
+		for _, l := range e.block {
+			if strings.HasSuffix(flow, "]") {
+				break
+			}
+
+			flow += " " + trim(l)
+		}
+
+		if !strings.HasSuffix(flow, "]") {
+			return nil, errorf(e.line, "unterminated list")
+		}
+
+		list := []string{}
+		for _, item := range strings.Split(flow[1:len(flow)-1], ",") {
+			if item = unquote(item); item != "" {
+				list = append(list, item)
+			}
+		}
+
+		return list, nil
+	case e.rest == "":
+		var list []string
+
+		for _, l := range e.block {
+			item := trim(l)
+			if item == "" {
+				continue
+			}
+
+			if !strings.HasPrefix(item, "-") {
+				return nil, errorf(e.line, "expected a list")
+			}
+
+			list = append(list, unquote(strings.TrimPrefix(item, "-")))
+		}
+
+		return list, nil
+	}
+
+	if strings.HasPrefix(e.rest, "{") {
+		return nil, errorf(e.line, "expected a list")
+	}
+
+	s, err := e.scalar()
+	if err != nil || s == "" {
+		return nil, err
+	}
+
+	return []string{s}, nil
+}
+
+// Interpret the entry as a string: a literal (|) or folded (>) block scalar,
+// or a plain or quoted scalar, which may span several lines.
+func (e entry) scalar() (string, error) {
+	if strings.HasPrefix(e.rest, "[") || strings.HasPrefix(e.rest, "{") {
+		return "", errorf(e.line, "expected a string")
+	}
+
+	switch e.rest {
+	case "|", "|-", "|+", ">", ">-", ">+":
+		return blockScalar(e.block, e.rest[0] == '>', e.rest[1:]), nil
+	}
+
+	words := []string{e.rest}
+	for _, l := range e.block {
+		if w := trim(l); w != "" {
+			if strings.HasPrefix(w, "-") && e.rest == "" {
+				return "", errorf(e.line, "expected a string")
+			}
+
+			words = append(words, w)
+		}
+	}
+
+	return unquote(strings.TrimSpace(strings.Join(words, " "))), nil
+}
+
+// Join the lines of a block scalar, removing the indentation of its first line
+// from each. The block ends at the first line which is less indented. Unless
+// [fold]ed, lines are joined by newlines; otherwise by spaces, with blank
+// lines kept as newlines. The final newline is kept, unless [chomp] is "-"
+// ("strip"), along with any trailing blank lines if it is "+" ("keep").
+func blockScalar(lines []string, fold bool, chomp string) string {
+	indent := ""
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			indent = l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+
+			break
+		}
+	}
+
+	for i, l := range lines {
+		if strings.TrimSpace(l) != "" && (indent == "" || !strings.HasPrefix(l, indent)) {
+			lines = lines[:i]
+
+			break
+		}
+
+		lines[i] = strings.TrimPrefix(l, indent)
+	}
+
+	trailing := 0
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	for i, l := range lines {
+		switch {
+		case i == 0:
+		case !fold:
+			b.WriteByte('\n')
+		case strings.TrimSpace(l) == "" || strings.TrimSpace(lines[i-1]) == "":
+			// A blank line of a folded block is a newline; the line break
+			// before (or after) it is dropped.
+		default:
+			b.WriteByte(' ')
+		}
+
+		if fold && strings.TrimSpace(l) == "" {
+			b.WriteByte('\n')
+
+			continue
+		}
+
+		b.WriteString(l)
+	}
+
+	switch chomp {
+	case "-":
+	case "+":
+		b.WriteString(strings.Repeat("\n", trailing+1))
+	default:
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
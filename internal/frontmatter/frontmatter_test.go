@@ -0,0 +1,60 @@
+package frontmatter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name, src string
+		expected  Frontmatter
+	}{
+		{"Empty", "", Frontmatter{}},
+		{"Flow", "title: Step Functions\npackages: [physics, 'tikz-cd']\n", Frontmatter{Packages: []string{"physics", "tikz-cd"}}},
+		{"Block", "packages:\n  - physics # for \\dv\n\n  - \"tikz-cd\"\ntitle: x\n", Frontmatter{Packages: []string{"physics", "tikz-cd"}}},
+		{"Scalar", "packages: physics\n", Frontmatter{Packages: []string{"physics"}}},
+		{
+			"Literal",
+			"preamble: |\n  \\newcommand{\\R}{\\mathbb{R}}\n\n  \\newcommand{\\norm}[1]{\\lVert #1 \\rVert}\n\npackages: []\n",
+			Frontmatter{Packages: []string{}, Preamble: "\\newcommand{\\R}{\\mathbb{R}}\n\n\\newcommand{\\norm}[1]{\\lVert #1 \\rVert}\n"},
+		},
+		{"Strip", "preamble: |-\n  \\usepackage{physics}\n", Frontmatter{Preamble: "\\usepackage{physics}"}},
+		{"MultilineFlow", "packages: [physics,\n  tikz-cd]\n", Frontmatter{Packages: []string{"physics", "tikz-cd"}}},
+		{"Comment", "packages:\n- physics\n# for diagrams\n- tikz-cd\n", Frontmatter{Packages: []string{"physics", "tikz-cd"}}},
+		{"Folded", "preamble: >-\n  \\newcommand{\\R}\n  {\\mathbb{R}}\n", Frontmatter{Preamble: "\\newcommand{\\R} {\\mathbb{R}}"}},
+		{"Plain", "preamble: \\newcommand{\\R}\n  {\\mathbb{R}}\n", Frontmatter{Preamble: "\\newcommand{\\R} {\\mathbb{R}}"}},
+		{
+			"Unknown",
+			"author:\n  name: x\n  links: [a,\n    b]\ndescription: >\n  Some\n\n  text: here\ntags:\n- a\npackages: [physics]\n",
+			Frontmatter{Packages: []string{"physics"}},
+		},
+	} {
+		actual, err := Parse(tc.src)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+
+			continue
+		}
+
+		if !slices.Equal(actual.Packages, tc.expected.Packages) || actual.Preamble != tc.expected.Preamble {
+			t.Errorf("%s: Expected: %#v\n\nActual: %#v", tc.name, tc.expected, actual)
+		}
+	}
+
+	for _, src := range []string{"packages [physics]", "  packages: [physics]", "packages: [physics", "preamble: [a, b]", "packages:\n  a: b"} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Expected error parsing %q", src)
+		}
+	}
+}
+
+func TestTexPreamble(t *testing.T) {
+	f := Frontmatter{Packages: []string{"physics", "tikz-cd"}, Preamble: "\\newcommand{\\R}{\\mathbb{R}}\n"}
+
+	expected := "\\usepackage{physics}\n\\usepackage{tikz-cd}\n\\newcommand{\\R}{\\mathbb{R}}\n"
+
+	if actual := f.TexPreamble(); actual != expected {
+		t.Errorf("Expected: %q\n\nActual: %q", expected, actual)
+	}
+}
@@ -0,0 +1,221 @@
+// package ignore matches paths against patterns written in the syntax of
+// .gitignore files, e.g.
+//
+//	# Obsidian's configuration and the notes' templates
+//	.obsidian/
+//	templates/
+//	drafts/**/*.md
+//	!drafts/published.md
+//
+// Patterns containing a slash (other than a trailing one) are anchored to the
+// root, others match at any depth. A trailing slash matches only directories,
+// and a leading ! re-includes what an earlier pattern ignored. As with git, a
+// path within an ignored directory can't be re-included.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// File holds the patterns of a site, at the root of its source directory.
+const File = ".webtexignore"
+
+// Temporary matches the files written by editors (vim, emacs, etc) while
+// saving.
+var Temporary = []string{
+	"4913", // vim checks whether it may create files in a directory
+	"*~",
+	".#*",
+	`\#*#`,
+	"*.swp",
+	"*.swx",
+	"*.swo",
+	"*.tmp",
+}
+
+// Defaults precede the patterns of every File, which may negate them. Dotfiles
+// (e.g. .git/ and .obsidian/) and Temporary files are ignored.
+var Defaults = append([]string{".*"}, Temporary...)
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool // Re-include matching paths.
+	dirOnly bool // Match only directories.
+}
+
+// Matcher reports whether paths are ignored. The zero value ignores nothing.
+type Matcher struct {
+	patterns []pattern
+}
+
+// Translate the glob [g] into a regular expression, in which * and ? don't
+// match a slash and ** matches any number of directories.
+func translate(g string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(g); i++ {
+		switch c := g[i]; {
+		case strings.HasPrefix(g[i:], "**/") && (i == 0 || g[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(g[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(g):
+			i++
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(g[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+
+				continue
+			}
+
+			class := g[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		}
+	}
+
+	return b.String()
+}
+
+// Compile a single line of an ignore file. Reports false for blank lines and
+// comments.
+func compile(line string) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || line[0] == '#' {
+		return pattern{}, false
+	}
+
+	var p pattern
+
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if line == "" {
+		return pattern{}, false
+	}
+
+	expr := "^"
+	if !strings.Contains(line, "/") {
+		// Match the name at any depth.
+		expr += "(?:.*/)?"
+	}
+
+	expr += translate(strings.TrimPrefix(line, "/")) + "$"
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return pattern{}, false
+	}
+
+	p.re = re
+
+	return p, true
+}
+
+// New compiles [patterns], each a line of an ignore file. Later patterns take
+// precedence over earlier ones.
+func New(patterns ...string) *Matcher {
+	m := &Matcher{}
+	m.Add(patterns...)
+
+	return m
+}
+
+// Add appends [patterns] to those of the Matcher.
+func (m *Matcher) Add(patterns ...string) {
+	for _, line := range patterns {
+		if p, ok := compile(line); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+}
+
+// Load creates a Matcher of the Defaults followed by the patterns of the File
+// in [root], if it has one.
+func Load(root string) (*Matcher, error) {
+	m := New(Defaults...)
+
+	f, err := os.Open(filepath.Join(root, File))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		m.Add(s.Text())
+	}
+
+	return m, s.Err()
+}
+
+// Report whether the last pattern matching [rel] ignores it.
+func (m *Matcher) match(rel string, isDir bool) bool {
+	ignored := false
+
+	for _, p := range m.patterns {
+		if (!p.dirOnly || isDir) && p.re.MatchString(rel) {
+			ignored = !p.negate
+		}
+	}
+
+	return ignored
+}
+
+// Ignored reports whether the path [rel], relative to the root and a directory
+// if [isDir], is ignored either itself or by way of a directory containing it.
+func (m *Matcher) Ignored(rel string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == "." {
+		return false
+	}
+
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' && m.match(rel[:i], true) {
+			return true
+		}
+	}
+
+	return m.match(rel, isDir)
+}
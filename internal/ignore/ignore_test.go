@@ -0,0 +1,97 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	m := New(
+		"# comment",
+		"",
+		"templates/",
+		"/root.md",
+		"drafts/**/*.md",
+		"!drafts/**/published.md",
+		"*.log",
+		"!keep.log",
+		`\#hash`,
+		"file[0-9].txt",
+		"**/cache",
+		"build/**",
+		"trailing   ",
+	)
+
+	for _, tc := range []struct {
+		rel      string
+		isDir    bool
+		expected bool
+	}{
+		{"notes.md", false, false},
+		{"templates", true, true},
+		{"templates", false, false},
+		{"templates/daily.md", false, true},
+		{"sub/templates/daily.md", false, true},
+		{"root.md", false, true},
+		{"sub/root.md", false, false},
+		{"drafts/a.md", false, true},
+		{"drafts/x/y/a.md", false, true},
+		{"drafts/x/published.md", false, false},
+		{"drafts/a.png", false, false},
+		{"a/b/debug.log", false, true},
+		{"keep.log", false, false},
+		{"#hash", false, true},
+		{"file1.txt", false, true},
+		{"fileA.txt", false, false},
+		{"x/y/cache", true, true},
+		{"build/out.html", false, true},
+		{"trailing", false, true},
+		{".", true, false},
+	} {
+		if actual := m.Ignored(filepath.FromSlash(tc.rel), tc.isDir); actual != tc.expected {
+			t.Errorf("%s (dir: %t): Expected %t, got %t", tc.rel, tc.isDir, tc.expected, actual)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	m := New(Defaults...)
+
+	for _, rel := range []string{".git/HEAD", ".obsidian/app.json", "notes/.DS_Store", "a.md~", "#a.md#", ".#a.md", "a.md.swp", "4913"} {
+		if !m.Ignored(filepath.FromSlash(rel), false) {
+			t.Errorf("Expected %s to be ignored", rel)
+		}
+	}
+
+	for _, rel := range []string{"a.md", "img/plot.png", "preamble.tex"} {
+		if m.Ignored(filepath.FromSlash(rel), false) {
+			t.Errorf("Expected %s not to be ignored", rel)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(root, File), []byte("drafts/\n!.well-known/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Ignored("drafts", true) || !m.Ignored(".git", true) {
+		t.Errorf("Expected the patterns of the file to follow the defaults")
+	}
+
+	if m.Ignored(".well-known", true) {
+		t.Errorf("Expected the file to negate the defaults")
+	}
+
+	if m, err := Load(t.TempDir()); err != nil || !m.Ignored(".git", true) {
+		t.Errorf("Expected the defaults without a file (%v)", err)
+	}
+}
@@ -0,0 +1,95 @@
+// package livereload notifies browsers viewing a development build of the site
+// that it has been rebuilt, using Server-Sent Events. Documents built with a
+// LiveReload URL subscribe to these events and reload themselves.
+package livereload
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Path is the conventional path on which the Broker is served.
+const Path = "/_webtex/reload"
+
+// Interval between comments sent to keep idle connections open through proxies.
+const keepAlive = 30 * time.Second
+
+// Broker is an http.Handler streaming reload events to every connected client.
+type Broker struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+// New creates a Broker with no clients.
+func New() *Broker {
+	return &Broker{clients: make(map[chan struct{}]struct{})}
+}
+
+// Reload notifies every connected client that it should reload.
+func (b *Broker) Reload() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for c := range b.clients {
+		// A client which hasn't yet processed the previous notification will
+		// reload anyway.
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *Broker) subscribe() chan struct{} {
+	c := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+
+	return c
+}
+
+func (b *Broker) unsubscribe(c chan struct{}) {
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	c := b.subscribe()
+	defer b.unsubscribe(c)
+
+	// Ensure the client knows it is connected before the first event.
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,97 @@
+package livereload
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	t.Run("Reload", func(t *testing.T) {
+		b := New()
+
+		srv := httptest.NewServer(b)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Unexpected Content-Type: %s", ct)
+		}
+
+		body := bufio.NewReader(resp.Body)
+
+		// Wait for the connection to be established before notifying.
+		if line, err := body.ReadString('\n'); err != nil || !strings.HasPrefix(line, ":") {
+			t.Fatalf("Expected greeting, got %q (%v)", line, err)
+		}
+
+		b.Reload()
+
+		events := make(chan string)
+		go func() {
+			for {
+				line, err := body.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if strings.HasPrefix(line, "event: ") {
+					events <- strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+				}
+			}
+		}()
+
+		select {
+		case e := <-events:
+			if e != "reload" {
+				t.Errorf("Expected reload event, got %q", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for reload event")
+		}
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		b := New()
+
+		srv := httptest.NewServer(b)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bufio.NewReader(resp.Body).ReadString('\n')
+		resp.Body.Close()
+
+		// The handler notices the client is gone and unsubscribes.
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			b.mu.Lock()
+			n := len(b.clients)
+			b.mu.Unlock()
+
+			if n == 0 {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("Client still subscribed after disconnecting")
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// Reloading with no clients must not block.
+		b.Reload()
+	})
+}
@@ -3,29 +3,47 @@
 package logger
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
-var logger *log.Logger
+var (
+	logger  *log.Logger
+	enabled atomic.Bool
+
+	// The parameters of Log and Error shadow the fmt package.
+	sprintf = fmt.Sprintf
+)
 
 func init() {
 	logger = log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile)
 }
 
-// Log outputs a message to STDOUT. [fmt] and [msg] are used the same way as
-// the fmt package.
+// SetDebug activates the logger if [debug] is true and silences it otherwise.
+// The logger is silent by default.
+func SetDebug(debug bool) {
+	enabled.Store(debug)
+}
+
+// Log outputs a message to STDERR, which keeps it apart from the output of
+// commands such as webtex render. [fmt] and [msg] are used the same way as the
+// fmt package.
 func Log(fmt string, msg ...any) {
-	logger.SetOutput(os.Stdout)
-	logger.Printf(fmt, msg...)
+	if !enabled.Load() {
+		return
+	}
 
-	// Probably misguided attempt to amortize switching the output, since I care
-	// mainly about error output => there ought to be more error log statements.
-	defer logger.SetOutput(os.Stderr)
+	logger.Output(2, sprintf(fmt, msg...))
 }
 
 // Error outputs a message to STDERR. [fmt] and [msg] are used in the same way
 // as the fmt package.
 func Error(fmt string, msg ...any) {
-	logger.Printf(fmt, msg...)
+	if !enabled.Load() {
+		return
+	}
+
+	logger.Output(2, sprintf(fmt, msg...))
 }
@@ -10,8 +10,11 @@ import (
 )
 
 const (
-	htmlFlags  = html.CommonFlags | html.HrefTargetBlank | html.TOC
-	extensions = parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
+	htmlFlags = html.CommonFlags | html.HrefTargetBlank | html.TOC
+	// LaTeX is extracted by the chunk lexer before we ever see the markdown. Any
+	// remaining dollar signs are literal, so gomarkdown mustn't treat them as
+	// MathJax.
+	extensions = (parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock) &^ parser.MathJax
 )
 
 // Since we'll be potentially be converting a lot of markdown, we want to avoid
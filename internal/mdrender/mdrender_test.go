@@ -1,6 +1,7 @@
 package mdrender
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -11,4 +12,12 @@ func TestRender(t *testing.T) {
 		Render("## Subheading")
 		Render("```python\n[x for x in range(1, 11)]\n```")
 	})
+
+	t.Run("Dollars", func(t *testing.T) {
+		html := Render("It costs \\$5, between $20 and $30.")
+
+		if !strings.Contains(html, "It costs $5, between $20 and $30.") {
+			t.Errorf("Dollar signs not rendered literally: %s", html)
+		}
+	})
 }
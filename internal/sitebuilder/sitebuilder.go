@@ -38,6 +38,7 @@ type Document struct {
 	Title      string // Title is the title of the document (for use in a <title> tag).
 	Content    string // Content is the main content of the page.
 	Navigation []Href // Navigation is a list of outgoing links from the current document
+	LiveReload string // LiveReload is the URL of a development server's reload events. Omitted if empty.
 }
 
 // HTMLDoc produces a complete HTML document with [content] as its body. The
@@ -2,6 +2,7 @@ package sitebuilder
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -13,4 +14,26 @@ func TestHTMLDoc(t *testing.T) {
 	t.Run("Basic", func(t *testing.T) {
 		HTMLDoc(os.Stdout, Document{Title: "Some Title", Content: "<p>hello, world!</p>"})
 	})
+
+	t.Run("LiveReload", func(t *testing.T) {
+		var out strings.Builder
+		if err := HTMLDoc(&out, Document{Title: "Dev", LiveReload: "/_webtex/reload"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(out.String(), `new EventSource("/_webtex/reload")`) {
+			t.Errorf("Live reload script missing:\n%s", out.String())
+		}
+	})
+
+	t.Run("Published", func(t *testing.T) {
+		var out strings.Builder
+		if err := HTMLDoc(&out, Document{Title: "Published"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(out.String(), "EventSource") {
+			t.Errorf("Live reload script included outside of development")
+		}
+	})
 }
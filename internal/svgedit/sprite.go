@@ -0,0 +1,98 @@
+package svgedit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Glyph outlines written by pdf2svg, e.g.
+	// <symbol overflow="visible" id="glyph0-1">...</symbol>
+	symbolRe = regexp.MustCompile(`<symbol\b([^>]*?)\sid=["']([^"']*)["']([^>]*)>((?s:.*?))</symbol>\s*`)
+	// Glyph outlines written by dvisvgm, e.g. <path id='g0-120' d='...'/>,
+	// which are only glyphs within the definitions of an SVG.
+	pathRe = regexp.MustCompile(`<path\b([^>]*?)\sid=["']([^"']*)["']([^>]*?)/>\s*`)
+	defsRe = regexp.MustCompile(`(?s)<defs>.*?</defs>`)
+	// References to symbols, capturing the ID referred to.
+	useRe = regexp.MustCompile(`(\s(?:xlink:)?href=["']#)([^"']*)`)
+)
+
+// Sprite collects the glyph symbols of many SVGs, such that each distinct glyph
+// is defined only once per page.
+//
+// The zero value is not usable; create Sprites with NewSprite.
+type Sprite struct {
+	prefix  string
+	ids     map[string]string // IDs of the symbols within the sprite, keyed by definition.
+	symbols strings.Builder   // Definitions of the symbols (or paths) within the sprite.
+}
+
+// NewSprite creates an empty Sprite, whose symbols have IDs beginning with
+// [prefix].
+func NewSprite(prefix string) *Sprite {
+	return &Sprite{prefix: prefix, ids: make(map[string]string)}
+}
+
+// Add moves the glyphs defined in [svg] into the Sprite and rewrites [svg] to
+// refer to them there. Glyphs are either symbols, as written by pdf2svg, or
+// paths within the definitions of [svg], as written by dvisvgm. They are
+// considered the same if their definitions (i.e. their outlines) are
+// identical, regardless of their IDs.
+func (s *Sprite) Add(svg string) string {
+	local := make(map[string]string)
+
+	// Move the glyph [id] into the sprite, unless its definition [def] is
+	// already there, and refer to it there instead. The glyph is written as
+	// [open], its ID within the sprite and then [close].
+	add := func(id, def, open, close string) {
+		shared, ok := s.ids[def]
+		if !ok {
+			shared = fmt.Sprintf("%s%d", s.prefix, len(s.ids))
+			s.ids[def] = shared
+
+			fmt.Fprintf(&s.symbols, "%s id=\"%s\"%s\n", open, shared, close)
+		}
+
+		local[id] = shared
+	}
+
+	svg = symbolRe.ReplaceAllStringFunc(svg, func(symbol string) string {
+		m := symbolRe.FindStringSubmatch(symbol)
+		add(m[2], m[1]+m[3]+">"+m[4], "<symbol"+m[1], m[3]+">"+m[4]+"</symbol>")
+
+		return ""
+	})
+
+	svg = defsRe.ReplaceAllStringFunc(svg, func(defs string) string {
+		return pathRe.ReplaceAllStringFunc(defs, func(path string) string {
+			m := pathRe.FindStringSubmatch(path)
+			add(m[2], "<path"+m[1]+m[3]+"/>", "<path"+m[1], m[3]+"/>")
+
+			return ""
+		})
+	})
+
+	return useRe.ReplaceAllStringFunc(svg, func(ref string) string {
+		m := useRe.FindStringSubmatch(ref)
+		if id, ok := local[m[2]]; ok {
+			return m[1] + id
+		}
+
+		return ref
+	})
+}
+
+// String renders the Sprite as a hidden SVG, which must be inlined into the
+// same HTML document as the SVGs added to it. Returns an empty string if no
+// symbols were added.
+func (s *Sprite) String() string {
+	if len(s.ids) == 0 {
+		return ""
+	}
+
+	// Definitions within an SVG with display: none aren't reliably rendered by
+	// browsers, so the sprite is instead given no size.
+	return `<svg xmlns="http://www.w3.org/2000/svg" aria-hidden="true" style="position: absolute; width: 0; height: 0; overflow: hidden">` +
+		"\n<defs>\n" + s.symbols.String() + "</defs>\n</svg>\n"
+}
@@ -0,0 +1,114 @@
+package svgedit
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A formula as output by pdf2svg, with a glyph for each of [paths].
+func formula(prefix string, paths ...string) string {
+	var b strings.Builder
+
+	b.WriteString("<svg>\n<defs>\n<g>\n")
+	for i, d := range paths {
+		b.WriteString(`<symbol overflow="visible" id="` + prefix + `glyph0-` + strconv.Itoa(i) + `">` + "\n")
+		b.WriteString(`<path style="stroke:none;" d="` + d + `"/>` + "\n</symbol>\n")
+	}
+	b.WriteString("</g>\n</defs>\n<g>\n")
+	for i := range paths {
+		b.WriteString(`<use xlink:href="#` + prefix + `glyph0-` + strconv.Itoa(i) + `" x="1" y="2"/>` + "\n")
+	}
+	b.WriteString("</g>\n</svg>")
+
+	return b.String()
+}
+
+// A formula as output by dvisvgm with --no-fonts, with a glyph for each of
+// [paths].
+func dvisvgmFormula(prefix string, paths ...string) string {
+	var b strings.Builder
+
+	b.WriteString("<svg version='1.1'>\n<defs>\n")
+	for i, d := range paths {
+		b.WriteString("<path id='" + prefix + "g0-" + strconv.Itoa(i) + "' d='" + d + "'/>\n")
+	}
+	b.WriteString("</defs>\n<g id='" + prefix + "page1'>\n")
+	for i := range paths {
+		b.WriteString("<use x='1' y='2' xlink:href='#" + prefix + "g0-" + strconv.Itoa(i) + "'/>\n")
+	}
+	b.WriteString("<path d='M 0 0 H 1' stroke='#000'/>\n</g>\n</svg>")
+
+	return b.String()
+}
+
+func TestSprite(t *testing.T) {
+	s := NewSprite("glyph-")
+
+	if s.String() != "" {
+		t.Errorf("Expected empty sprite, got %q", s.String())
+	}
+
+	a := s.Add(formula("m0-", "M 1 1 Z", "M 2 2 Z"))
+	b := s.Add(formula("m1-", "M 2 2 Z", "M 3 3 Z"))
+
+	expected := map[string]string{
+		"a": "<svg>\n<defs>\n<g>\n</g>\n</defs>\n<g>\n" +
+			`<use xlink:href="#glyph-0" x="1" y="2"/>` + "\n" +
+			`<use xlink:href="#glyph-1" x="1" y="2"/>` + "\n</g>\n</svg>",
+		"b": "<svg>\n<defs>\n<g>\n</g>\n</defs>\n<g>\n" +
+			`<use xlink:href="#glyph-1" x="1" y="2"/>` + "\n" +
+			`<use xlink:href="#glyph-2" x="1" y="2"/>` + "\n</g>\n</svg>",
+	}
+
+	for name, actual := range map[string]string{"a": a, "b": b} {
+		if actual != expected[name] {
+			t.Errorf("Expected:\n%s\n\nActual:\n%s", expected[name], actual)
+		}
+	}
+
+	sprite := s.String()
+
+	if n := strings.Count(sprite, "<symbol"); n != 3 {
+		t.Errorf("Expected 3 distinct symbols, got %d:\n%s", n, sprite)
+	}
+
+	for i, d := range []string{"M 1 1 Z", "M 2 2 Z", "M 3 3 Z"} {
+		symbol := `<symbol overflow="visible" id="glyph-` + strconv.Itoa(i) + `">` + "\n" + `<path style="stroke:none;" d="` + d + `"/>`
+		if !strings.Contains(sprite, symbol) {
+			t.Errorf("Expected %s in:\n%s", symbol, sprite)
+		}
+	}
+}
+
+func TestSpritePaths(t *testing.T) {
+	s := NewSprite("glyph-")
+
+	a := s.Add(dvisvgmFormula("m0-0-", "M 1 1 Z", "M 2 2 Z"))
+	b := s.Add(dvisvgmFormula("m1-0-", "M 2 2 Z"))
+
+	expected := map[string]string{
+		"a": "<svg version='1.1'>\n<defs>\n</defs>\n<g id='m0-0-page1'>\n" +
+			"<use x='1' y='2' xlink:href='#glyph-0'/>\n" +
+			"<use x='1' y='2' xlink:href='#glyph-1'/>\n" +
+			"<path d='M 0 0 H 1' stroke='#000'/>\n</g>\n</svg>",
+		"b": "<svg version='1.1'>\n<defs>\n</defs>\n<g id='m1-0-page1'>\n" +
+			"<use x='1' y='2' xlink:href='#glyph-1'/>\n" +
+			"<path d='M 0 0 H 1' stroke='#000'/>\n</g>\n</svg>",
+	}
+
+	for name, actual := range map[string]string{"a": a, "b": b} {
+		if actual != expected[name] {
+			t.Errorf("Expected:\n%s\n\nActual:\n%s", expected[name], actual)
+		}
+	}
+
+	sprite := s.String()
+
+	for i, d := range []string{"M 1 1 Z", "M 2 2 Z"} {
+		path := `<path id="glyph-` + strconv.Itoa(i) + `" d='` + d + `'/>`
+		if strings.Count(sprite, path) != 1 {
+			t.Errorf("Expected a single %s in:\n%s", path, sprite)
+		}
+	}
+}
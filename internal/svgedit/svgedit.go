@@ -0,0 +1,42 @@
+// package svgedit makes textual edits to the SVGs produced by texrender so that
+// many of them may be inlined into the same HTML document.
+//
+// pdf2svg and dvisvgm write SVGs in a small, predictable subset of the format,
+// so edits are made with regular expressions rather than by parsing the XML.
+package svgedit
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Element IDs, e.g. id="glyph0-1"
+	idRe = regexp.MustCompile(`(\sid=["'])`)
+	// References to IDs by URL, e.g. xlink:href="#glyph0-1"
+	hrefRe = regexp.MustCompile(`(\s(?:xlink:)?href=["']#)`)
+	// References to IDs from properties, e.g. clip-path="url(#clip1)"
+	urlRe = regexp.MustCompile(`(url\(['"]?#)`)
+	// Fonts embedded by dvisvgm, e.g. font-family:cmr10
+	fontRe = regexp.MustCompile(`(font-family:\s*)`)
+	// Classes of text set in an embedded font, e.g. text.f0 and class='f0'
+	classRe = regexp.MustCompile(`(text\.|\sclass=["'])(f\d+\b)`)
+)
+
+// Prefix rewrites every ID defined in [svg], and every reference to one, to
+// begin with [prefix]. IDs are global to an HTML document, so SVGs inlined into
+// the same document must be prefixed uniquely lest they use each other's
+// glyphs. The same applies to the fonts and classes of embedded fonts, whose
+// styles are global too and which hold a different subset of glyphs per SVG.
+func Prefix(svg, prefix string) string {
+	// The prefix is expanded as a template by ReplaceAllString.
+	prefix = strings.ReplaceAll(prefix, "$", "$$")
+
+	svg = idRe.ReplaceAllString(svg, "${1}"+prefix)
+	svg = hrefRe.ReplaceAllString(svg, "${1}"+prefix)
+	svg = urlRe.ReplaceAllString(svg, "${1}"+prefix)
+	svg = fontRe.ReplaceAllString(svg, "${1}"+prefix)
+	svg = classRe.ReplaceAllString(svg, "${1}"+prefix+"${2}")
+
+	return svg
+}
@@ -0,0 +1,75 @@
+package svgedit
+
+import "testing"
+
+func TestPrefix(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="10pt" height="8pt" viewBox="0 0 10 8" version="1.1">
+<defs>
+<g>
+<symbol overflow="visible" id="glyph0-1">
+<path style="stroke:none;" d="M 1 0 L 2 1 Z "/>
+</symbol>
+</g>
+<clipPath id="clip1">
+  <path d="M 0 0 L 10 0 L 10 8 Z "/>
+</clipPath>
+</defs>
+<g id="surface1">
+<g clip-path="url(#clip1)" clip-rule="nonzero">
+<use xlink:href="#glyph0-1" x="1" y="7"/>
+<use href="#glyph0-1" x="5" y="7"/>
+</g>
+</g>
+</svg>`
+
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="10pt" height="8pt" viewBox="0 0 10 8" version="1.1">
+<defs>
+<g>
+<symbol overflow="visible" id="m$1-glyph0-1">
+<path style="stroke:none;" d="M 1 0 L 2 1 Z "/>
+</symbol>
+</g>
+<clipPath id="m$1-clip1">
+  <path d="M 0 0 L 10 0 L 10 8 Z "/>
+</clipPath>
+</defs>
+<g id="m$1-surface1">
+<g clip-path="url(#m$1-clip1)" clip-rule="nonzero">
+<use xlink:href="#m$1-glyph0-1" x="1" y="7"/>
+<use href="#m$1-glyph0-1" x="5" y="7"/>
+</g>
+</g>
+</svg>`
+
+	if actual := Prefix(svg, "m$1-"); actual != expected {
+		t.Errorf("Expected:\n%s\n\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestPrefixFonts(t *testing.T) {
+	svg := `<svg version='1.1' xmlns='http://www.w3.org/2000/svg' width='9.96pt' height='6.83pt' viewBox='0 0 9.96 6.83'>
+<style type='text/css'>
+<![CDATA[@font-face{font-family:cmr10;src:url(data:application/x-font-woff2;base64,d09GMgAB) format('woff2');}
+text.f0 {font-family:cmr10;font-size:9.96px}
+]]>
+</style>
+<g id='page1'>
+<text class='f0' x='0' y='6.83'>x</text>
+</g>
+</svg>`
+
+	expected := `<svg version='1.1' xmlns='http://www.w3.org/2000/svg' width='9.96pt' height='6.83pt' viewBox='0 0 9.96 6.83'>
+<style type='text/css'>
+<![CDATA[@font-face{font-family:m1-cmr10;src:url(data:application/x-font-woff2;base64,d09GMgAB) format('woff2');}
+text.m1-f0 {font-family:m1-cmr10;font-size:9.96px}
+]]>
+</style>
+<g id='m1-page1'>
+<text class='m1-f0' x='0' y='6.83'>x</text>
+</g>
+</svg>`
+
+	if actual := Prefix(svg, "m1-"); actual != expected {
+		t.Errorf("Expected:\n%s\n\nActual:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,151 @@
+package texrender
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Backend converts the output of TeX into an SVG.
+type Backend string
+
+const (
+	PDF2SVG Backend = "pdf2svg" // pdf2svg converts PDFs, drawing glyphs as paths.
+	DVISVGM Backend = "dvisvgm" // dvisvgm converts PDFs or DVIs, drawing glyphs as paths or embedding fonts.
+)
+
+// ParseBackend validates the name of a Backend. The empty string selects a
+// Backend automatically.
+func ParseBackend(name string) (Backend, error) {
+	switch b := Backend(name); b {
+	case "", PDF2SVG, DVISVGM:
+		return b, nil
+	}
+
+	return "", fmt.Errorf("texrender: unsupported backend %q", name)
+}
+
+// converter is a Backend as configured by Options.
+type converter struct {
+	backend Backend
+	path    string
+	dvi     bool // Convert DVI (or XDV) output by TeX, rather than PDF.
+	fonts   bool // Embed fonts as WOFF2, rather than drawing glyphs as paths.
+}
+
+// Choose the converter configured by [opts]. Without a Backend, pdf2svg is
+// preferred if it is installed, as it doesn't require Ghostscript to convert
+// PDFs.
+func newConverter(opts Options) (converter, error) {
+	c := converter{backend: opts.Backend, dvi: opts.DVI, fonts: opts.Fonts}
+
+	if c.backend == "" {
+		c.backend = PDF2SVG
+
+		if _, err := exec.LookPath(string(PDF2SVG)); err != nil || c.dvi || c.fonts {
+			c.backend = DVISVGM
+		}
+	}
+
+	if _, err := ParseBackend(string(c.backend)); err != nil {
+		return c, err
+	}
+
+	if c.backend == PDF2SVG && (c.dvi || c.fonts) {
+		return c, fmt.Errorf("texrender: DVI output and fonts require %s", DVISVGM)
+	}
+
+	path, err := exec.LookPath(string(c.backend))
+	if err != nil {
+		return c, err
+	}
+
+	c.path = path
+
+	return c, nil
+}
+
+// Describe the converter for the cache key. pdf2svg has no --version, so is
+// identified by its path alone.
+func (c converter) key() string {
+	if c.backend == PDF2SVG {
+		return c.path
+	}
+
+	return fmt.Sprintf("%s dvi=%t fonts=%t", engineVersion(c.path), c.dvi, c.fonts)
+}
+
+// Additional arguments to [engine] in order to produce output for conversion.
+func (c converter) engineArgs(engine Engine) []string {
+	switch {
+	case !c.dvi:
+		return nil
+	case engine == XeLaTeX:
+		return []string{"-no-pdf"}
+	default:
+		return []string{"-output-format=dvi"}
+	}
+}
+
+// The PGF driver to use with [engine], if not the default. TikZ pictures are
+// otherwise written as PostScript specials in DVI, which dvisvgm converts only
+// with the help of Ghostscript.
+func (c converter) pgfDriver(engine Engine) string {
+	if c.dvi && engine != XeLaTeX {
+		return "pgfsys-dvisvgm.def"
+	}
+
+	return ""
+}
+
+// The name of the file output by [engine] for conversion.
+func (c converter) input(engine Engine) string {
+	switch {
+	case !c.dvi:
+		return "texput.pdf"
+	case engine == XeLaTeX:
+		return "texput.xdv"
+	default:
+		return "texput.dvi"
+	}
+}
+
+// Convert the output of [engine] within [dir] into texput.svg.
+func (c converter) convert(ctx context.Context, dir string, engine Engine) error {
+	var args []string
+
+	switch c.backend {
+	case PDF2SVG:
+		args = []string{c.input(engine), "texput.svg"}
+	case DVISVGM:
+		args = []string{"--output=texput.svg"}
+
+		if c.dvi {
+			// Respect the page size set by the standalone class, which inline
+			// LaTeX relies upon for alignment.
+			args = append(args, "--bbox=papersize")
+		} else {
+			args = append(args, "--pdf")
+		}
+
+		if c.fonts {
+			args = append(args, "--font-format=woff2")
+		} else {
+			args = append(args, "--no-fonts")
+		}
+
+		args = append(args, c.input(engine))
+	}
+
+	if out, err := command(ctx, dir, c.path, args...).CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("%s: %w: %s", filepath.Base(c.path), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package texrender
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestParseBackend(t *testing.T) {
+	for _, name := range []string{"", "pdf2svg", "dvisvgm"} {
+		if b, err := ParseBackend(name); err != nil || string(b) != name {
+			t.Errorf("Expected %q, got %q (%v)", name, b, err)
+		}
+	}
+
+	if _, err := ParseBackend("inkscape"); err == nil {
+		t.Errorf("Expected error for unsupported backend")
+	}
+}
+
+func TestConverter(t *testing.T) {
+	pdf := converter{backend: DVISVGM}
+	dvi := converter{backend: DVISVGM, dvi: true}
+
+	for _, tc := range []struct {
+		name   string
+		c      converter
+		engine Engine
+		input  string
+		args   []string
+		driver string
+	}{
+		{"PDF", pdf, PDFLaTeX, "texput.pdf", nil, ""},
+		{"DVI", dvi, PDFLaTeX, "texput.dvi", []string{"-output-format=dvi"}, "pgfsys-dvisvgm.def"},
+		{"LuaLaTeX", dvi, LuaLaTeX, "texput.dvi", []string{"-output-format=dvi"}, "pgfsys-dvisvgm.def"},
+		{"XeLaTeX", dvi, XeLaTeX, "texput.xdv", []string{"-no-pdf"}, ""},
+	} {
+		if input := tc.c.input(tc.engine); input != tc.input {
+			t.Errorf("%s: Expected input %s, got %s", tc.name, tc.input, input)
+		}
+
+		if args := tc.c.engineArgs(tc.engine); !slices.Equal(args, tc.args) {
+			t.Errorf("%s: Expected arguments %q, got %q", tc.name, tc.args, args)
+		}
+
+		if driver := tc.c.pgfDriver(tc.engine); driver != tc.driver {
+			t.Errorf("%s: Expected driver %q, got %q", tc.name, tc.driver, driver)
+		}
+	}
+}
+
+func TestNewConverter(t *testing.T) {
+	if _, err := newConverter(Options{Backend: PDF2SVG, DVI: true}); err == nil {
+		t.Errorf("Expected error for DVI output with pdf2svg")
+	}
+
+	if _, err := exec.LookPath("dvisvgm"); err != nil {
+		t.Skip("dvisvgm is not installed")
+	}
+
+	c, err := newConverter(Options{Fonts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.backend != DVISVGM {
+		t.Errorf("Expected fonts to select %s, got %s", DVISVGM, c.backend)
+	}
+}
+
+func TestConverterKey(t *testing.T) {
+	// Renders by different installations of pdf2svg are cached apart.
+	a := converter{backend: PDF2SVG, path: "/usr/bin/pdf2svg"}
+	b := converter{backend: PDF2SVG, path: "/usr/local/bin/pdf2svg"}
+
+	if a.key() == "" || a.key() == b.key() {
+		t.Errorf("Expected distinct keys, got %q and %q", a.key(), b.key())
+	}
+}
@@ -0,0 +1,251 @@
+package texrender
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the size limit used when a Cache is created with a
+// non-positive limit.
+const DefaultCacheSize = 256 << 20
+
+// Cache is a persistent, content-addressed store of rendered SVGs. Entries are
+// keyed by a hash of everything that influences the output of a render (the
+// complete TeX document and the version of the engine), so a stale entry is
+// never returned; it simply stops being requested and is eventually evicted.
+//
+// Entries are evicted least recently used first once the total size of the
+// cache exceeds its limit. A Cache is safe for concurrent use.
+type Cache struct {
+	dir   string
+	limit int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+// DefaultCacheDir returns the directory used to cache SVGs when none is
+// specified, e.g. $XDG_CACHE_HOME/webtex on Linux.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "webtex"), nil
+}
+
+// NewCache opens (or creates) a cache rooted at [dir] holding at most [limit]
+// bytes of SVGs.
+func NewCache(dir string, limit int64) (*Cache, error) {
+	if limit <= 0 {
+		limit = DefaultCacheSize
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{dir: dir, limit: limit}
+
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		c.size += e.size
+	}
+
+	return c, nil
+}
+
+// cacheKey hashes the inputs of a render into a key suitable for the Cache.
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+
+	for _, p := range parts {
+		h.Write([]byte(p))
+		// Separate the parts so that ("ab", "c") and ("a", "bc") differ.
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Entries are sharded by the first two characters of their key to avoid huge
+// directories.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".svg")
+}
+
+// Get returns the SVG stored under [key], if any. A hit marks the entry as
+// recently used.
+func (c *Cache) Get(key string) (string, bool) {
+	path := c.path(key)
+
+	svg, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return string(svg), true
+}
+
+// Put stores [svg] under [key], evicting old entries if the cache grows beyond
+// its limit.
+func (c *Cache) Put(key, svg string) error {
+	path := c.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename so that concurrent readers (or other
+	// webtex processes) never observe a partially written entry.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.WriteString(svg); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var prev int64
+	if fi, err := os.Stat(path); err == nil {
+		prev = fi.Size()
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	c.size += int64(len(svg)) - prev
+
+	if c.size > c.limit {
+		return c.evict()
+	}
+
+	return nil
+}
+
+// Purge removes every entry from the cache.
+func (c *Cache) Purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(c.dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	c.size = 0
+
+	return nil
+}
+
+// Size reports the total size in bytes of the entries in the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+type entry struct {
+	path  string
+	size  int64
+	atime time.Time
+}
+
+func (c *Cache) entries() ([]entry, error) {
+	var entries []entry
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(path, ".svg") {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, entry{path, fi.Size(), fi.ModTime()})
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Remove the least recently used entries until the cache is within 90% of its
+// limit, leaving some headroom so that we don't evict on every subsequent Put.
+//
+// Must be called with c.mu held.
+func (c *Cache) evict() error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].atime.Before(entries[j].atime)
+	})
+
+	c.size = 0
+	for _, e := range entries {
+		c.size += e.size
+	}
+
+	target := c.limit / 10 * 9
+
+	for _, e := range entries {
+		if c.size <= target {
+			break
+		}
+
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		c.size -= e.size
+	}
+
+	return nil
+}
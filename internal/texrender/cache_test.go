@@ -0,0 +1,141 @@
+package texrender
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	t.Run("Deterministic", func(t *testing.T) {
+		if cacheKey("pdfTeX 3.14", "doc") != cacheKey("pdfTeX 3.14", "doc") {
+			t.Errorf("Identical inputs produced different keys")
+		}
+	})
+
+	t.Run("Separated", func(t *testing.T) {
+		if cacheKey("ab", "c") == cacheKey("a", "bc") {
+			t.Errorf("Distinct inputs produced the same key")
+		}
+	})
+}
+
+func TestCache(t *testing.T) {
+	t.Run("Miss", func(t *testing.T) {
+		c, err := NewCache(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := c.Get(cacheKey("missing")); ok {
+			t.Errorf("Cache hit on empty cache")
+		}
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		c, err := NewCache(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := cacheKey("x + y = z")
+		if err := c.Put(key, "<svg></svg>"); err != nil {
+			t.Fatal(err)
+		}
+
+		svg, ok := c.Get(key)
+		if !ok || svg != "<svg></svg>" {
+			t.Errorf("Expected cached SVG, got %q (hit: %v)", svg, ok)
+		}
+	})
+
+	t.Run("Persistent", func(t *testing.T) {
+		dir := t.TempDir()
+
+		c, err := NewCache(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := cacheKey("a^2 + b^2 = c^2")
+		if err := c.Put(key, "<svg/>"); err != nil {
+			t.Fatal(err)
+		}
+
+		reopened, err := NewCache(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := reopened.Get(key); !ok {
+			t.Errorf("Entry did not survive reopening the cache")
+		}
+
+		if reopened.Size() != c.Size() {
+			t.Errorf("Expected size %d, got %d", c.Size(), reopened.Size())
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		c, err := NewCache(t.TempDir(), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		svg := strings.Repeat("x", 40)
+		old, recent := cacheKey("old"), cacheKey("recent")
+
+		if err := c.Put(old, svg); err != nil {
+			t.Fatal(err)
+		}
+
+		// Make sure the access times are distinguishable.
+		past := time.Now().Add(-time.Hour)
+		os.Chtimes(c.path(old), past, past)
+
+		if err := c.Put(recent, svg); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Put(cacheKey("new"), svg); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := c.Get(old); ok {
+			t.Errorf("Least recently used entry was not evicted")
+		}
+
+		if _, ok := c.Get(recent); !ok {
+			t.Errorf("Recently used entry was evicted")
+		}
+
+		if c.Size() > 100 {
+			t.Errorf("Cache exceeds its limit: %d bytes", c.Size())
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		c, err := NewCache(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := cacheKey("purged")
+		if err := c.Put(key, "<svg/>"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Purge(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := c.Get(key); ok {
+			t.Errorf("Entry survived purge")
+		}
+
+		if c.Size() != 0 {
+			t.Errorf("Expected empty cache, got %d bytes", c.Size())
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package texrender
+
+import "fmt"
+
+// Engine is the TeX engine which compiles documents into PDFs.
+type Engine string
+
+const (
+	PDFLaTeX Engine = "pdflatex"
+	XeLaTeX  Engine = "xelatex"  // XeLaTeX supports Unicode input and system fonts via fontspec.
+	LuaLaTeX Engine = "lualatex" // LuaLaTeX supports Unicode input and system fonts via fontspec.
+)
+
+// DefaultEngine is used when no Engine is specified.
+const DefaultEngine = PDFLaTeX
+
+// ParseEngine validates the name of an Engine. The empty string names the
+// DefaultEngine.
+func ParseEngine(name string) (Engine, error) {
+	switch e := Engine(name); e {
+	case "":
+		return DefaultEngine, nil
+	case PDFLaTeX, XeLaTeX, LuaLaTeX:
+		return e, nil
+	}
+
+	return "", fmt.Errorf("texrender: unsupported engine %q", name)
+}
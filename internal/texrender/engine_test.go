@@ -0,0 +1,15 @@
+package texrender
+
+import "testing"
+
+func TestParseEngine(t *testing.T) {
+	for name, expected := range map[string]Engine{"": PDFLaTeX, "pdflatex": PDFLaTeX, "xelatex": XeLaTeX, "lualatex": LuaLaTeX} {
+		if e, err := ParseEngine(name); err != nil || e != expected {
+			t.Errorf("Expected %s for %q, got %s (%v)", expected, name, e, err)
+		}
+	}
+
+	if _, err := ParseEngine("latex"); err == nil {
+		t.Errorf("Expected error for unsupported engine")
+	}
+}
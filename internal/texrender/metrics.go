@@ -0,0 +1,72 @@
+package texrender
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Points per em of the standalone document, whose font size is 10pt.
+const ptPerEm = 10.0
+
+// Typeset inline [tex] in a box and report its dimensions to the log so that
+// the SVG can later be aligned with the surrounding text. Everything is kept on
+// the line of [tex] itself so that errors can still be traced back to it.
+func measure(tex string) string {
+	return fmt.Sprintf(`\sbox0{$%s$}\typeout{webtex-metrics:\the\wd0,\the\ht0,\the\dp0}\usebox0`, tex)
+}
+
+// metrics are the dimensions of a box typeset by TeX, in points. The height
+// extends above the baseline and the depth below it.
+type metrics struct {
+	width, height, depth float64
+}
+
+var metricsRe = regexp.MustCompile(`webtex-metrics:(-?[\d.]+)pt,(-?[\d.]+)pt,(-?[\d.]+)pt`)
+
+// Extract the metrics reported by measure from the [log] produced by TeX.
+func parseMetrics(log string) (metrics, bool) {
+	m := metricsRe.FindStringSubmatch(log)
+	if m == nil {
+		return metrics{}, false
+	}
+
+	var dims [3]float64
+	for i := range dims {
+		d, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return metrics{}, false
+		}
+
+		dims[i] = d
+	}
+
+	return metrics{width: dims[0], height: dims[1], depth: dims[2]}, true
+}
+
+var (
+	svgRootRe = regexp.MustCompile(`<svg\b[^>]*>`)
+	// Attributes of the root element which determine its size on the page.
+	svgSizeRe = regexp.MustCompile(`\s(?:width|height|style)=(?:"[^"]*"|'[^']*')`)
+)
+
+func em(pt float64) string {
+	return strconv.FormatFloat(pt/ptPerEm, 'f', 4, 64) + "em"
+}
+
+// Size [svg] in ems according to [m], lowering it such that the baseline of the
+// formula sits on the baseline of the surrounding text.
+func align(svg string, m metrics) string {
+	loc := svgRootRe.FindStringIndex(svg)
+	if loc == nil {
+		return svg
+	}
+
+	root := svgSizeRe.ReplaceAllString(svg[loc[0]:loc[1]], "")
+	root = strings.TrimSuffix(root, ">")
+	root += fmt.Sprintf(` width="%s" height="%s" style="vertical-align: %s"`,
+		em(m.width), em(m.height+m.depth), em(-m.depth))
+
+	return svg[:loc[0]] + root + ">" + svg[loc[1]:]
+}
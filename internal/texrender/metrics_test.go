@@ -0,0 +1,103 @@
+package texrender
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMetrics(t *testing.T) {
+	log := strings.Join([]string{
+		"(./texput.tex",
+		"webtex-metrics:25.83339pt,6.94444pt,1.94444pt",
+		"[1] (./texput.aux) )",
+	}, "\n")
+
+	m, ok := parseMetrics(log)
+	if !ok {
+		t.Fatal("Expected metrics")
+	}
+
+	if m != (metrics{width: 25.83339, height: 6.94444, depth: 1.94444}) {
+		t.Errorf("Unexpected metrics: %+v", m)
+	}
+
+	if _, ok := parseMetrics("(./texput.tex [1] )"); ok {
+		t.Errorf("Expected no metrics")
+	}
+}
+
+func TestAlign(t *testing.T) {
+	svg := `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="25.833pt" height="8.889pt" viewBox="0 0 25.833 8.889" version="1.1">
+<g id="surface1"></g>
+</svg>`
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 25.833 8.889" version="1.1" width="2.5833em" height="0.8889em" style="vertical-align: -0.1944em">
+<g id="surface1"></g>
+</svg>`
+
+	if actual := align(svg, metrics{width: 25.833, height: 6.945, depth: 1.944}); actual != expected {
+		t.Errorf("Expected:\n%s\n\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestRenderInline(t *testing.T) {
+	for _, bin := range []string{"pdflatex", "pdf2svg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	svg, err := RenderInline(context.Background(), "x_1^2", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(svg, `style="vertical-align: -`) {
+		t.Errorf("Expected SVG to be lowered below the baseline:\n%s", svg)
+	}
+}
+
+func TestRenderInlineError(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	// Stand-ins for TeX, which rejects the line of texput.tex naming the
+	// undefined macro, and for pdf2svg, which is never reached.
+	bin := t.TempDir()
+	tools := map[string]string{
+		"pdflatex": `line=$(grep -n undefinedmacro texput.tex | cut -d: -f1)
+echo "./texput.tex:$line: Undefined control sequence." > texput.log
+exit 1`,
+		"pdf2svg": "exit 1",
+	}
+
+	for name, script := range tools {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tex := `x + \undefinedmacro`
+
+	_, err := RenderInline(context.Background(), tex, Options{})
+
+	var texErr *TexError
+	if !errors.As(err, &texErr) {
+		t.Fatalf("Expected *TexError, got %v", err)
+	}
+
+	// The error is reported against the author's LaTeX, not that measuring it.
+	if texErr.Line != 1 || texErr.Context != tex {
+		t.Errorf("Expected line 1 (%q), got %d (%q)", tex, texErr.Line, texErr.Context)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package texrender
+
+import "os/exec"
+
+// Process groups are unavailable, so only [cmd] itself is killed on
+// cancellation.
+func setProcessGroup(cmd *exec.Cmd) {}
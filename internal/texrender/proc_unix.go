@@ -0,0 +1,18 @@
+//go:build unix
+
+package texrender
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run [cmd] in a process group of its own so that cancelling it also kills any
+// processes it spawned, e.g. Ghostscript beneath dvisvgm or a shell escape.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,89 @@
+package texrender
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TexError describes the first error reported by TeX while rendering a chunk.
+type TexError struct {
+	Message string // Message is the error reported by TeX, e.g "Undefined control sequence."
+	Line    int    // Line is the 1-indexed line of the chunk's content at fault, or 0 if unknown.
+	Context string // Context is the offending line of the chunk's content.
+	RawLog  string // RawLog is the complete log output by TeX.
+}
+
+func (e *TexError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("tex: %s", e.Message)
+	}
+
+	return fmt.Sprintf("tex: line %d: %s\n\t%s", e.Line, e.Message, e.Context)
+}
+
+var (
+	// Emitted with -file-line-error, e.g: ./texput.tex:7: Undefined control sequence.
+	fileLineRe = regexp.MustCompile(`^(?:\./)?texput\.tex:(\d+): (.*)$`)
+	// Errors that TeX reports in its traditional format, e.g: ! Emergency stop.
+	bangRe = regexp.MustCompile(`^! (.*)$`)
+	// TeX reports the line it was reading when the error occurred as: l.7 \foo
+	lineRe = regexp.MustCompile(`^l\.(\d+) `)
+)
+
+// Extract the first error from the [log] produced by TeX when compiling [doc].
+// Line numbers are mapped back onto [tex], the content of the chunk being
+// rendered, which begins on line [start] of the document.
+//
+// Returns nil if the log contains no recognizable errors.
+func parseLog(log, tex string, start int) *TexError {
+	var (
+		message string
+		line    int
+	)
+
+	lines := strings.Split(log, "\n")
+
+	for i, l := range lines {
+		l = strings.TrimRight(l, "\r")
+
+		if m := fileLineRe.FindStringSubmatch(l); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			message = m[2]
+
+			break
+		}
+
+		if m := bangRe.FindStringSubmatch(l); m != nil {
+			message = m[1]
+
+			// The line number follows shortly after the message
+			for _, next := range lines[i+1:] {
+				if m := lineRe.FindStringSubmatch(next); m != nil {
+					line, _ = strconv.Atoi(m[1])
+
+					break
+				}
+			}
+
+			break
+		}
+	}
+
+	if message == "" {
+		return nil
+	}
+
+	err := &TexError{Message: message, RawLog: log}
+
+	// Errors in the preamble (e.g a missing package) or at the end of the
+	// document do not correspond to any line of the chunk.
+	src := strings.Split(tex, "\n")
+	if n := line - start + 1; line > 0 && n >= 1 && n <= len(src) {
+		err.Line = n
+		err.Context = src[n-1]
+	}
+
+	return err
+}
@@ -1,108 +1,290 @@
 // package texrender converts TeX code into SVGs. The host machine must have:
 //
-//   - pdflatex (and required packages)
-//   - pdf2svg
+//   - pdflatex, xelatex or lualatex (and required packages)
+//   - pdf2svg or dvisvgm
 //
 // in order to function.
 package texrender
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/beautifultovarisch/webtex/internal/logger"
 )
 
-// Format proper latex document
-func texDoc(tex string) string {
+// Options configure how TeX is rendered. The zero value is ready to use.
+type Options struct {
+	Cache   *Cache  // Cache, if non-nil, is consulted before invoking TeX.
+	Engine  Engine  // Engine compiles the TeX. Defaults to DefaultEngine.
+	Backend Backend // Backend converts the output of TeX. Defaults to pdf2svg if installed, otherwise dvisvgm.
+	DVI     bool    // DVI has dvisvgm convert DVI rather than PDF, which is faster.
+	Fonts   bool    // Fonts has dvisvgm embed fonts as WOFF2, rather than drawing glyphs as paths.
+
+	// Timeout bounds each render, after which TeX (and any process it spawned)
+	// is killed. Zero means no limit.
+	Timeout time.Duration
+
+	// Preamble follows the default packages in the preamble of every document,
+	// e.g. \usepackage{physics}. Like the rest of the document, it forms part of
+	// the cache key.
+	Preamble string
+
+	// Macros are definitions made ahead of every formula, following the
+	// Preamble, e.g. \newcommand{\R}{\mathbb{R}}.
+	Macros string
+}
+
+// Memoized output of `<engine> --version`, keyed by the path of the engine.
+var versions sync.Map
+
+const beginDocument = "\\begin{document}%\n"
+
+// Join sections of LaTeX, each on their own lines.
+func joinLines(sections ...string) string {
 	var b strings.Builder
 
-	b.WriteString("\\documentclass{standalone}\n")
+	for _, s := range sections {
+		if s == "" {
+			continue
+		}
+
+		b.WriteString(s)
+		if !strings.HasSuffix(s, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.String()
+}
+
+// Format proper latex document. [class] holds any options for the standalone
+// document class, [driver] the PGF system driver if not the default, and
+// [preamble] any additions to the preamble.
+func texDoc(class, driver, preamble, tex string) string {
+	var b strings.Builder
+
+	if class != "" {
+		fmt.Fprintf(&b, "\\documentclass[%s]{standalone}\n", class)
+	} else {
+		b.WriteString("\\documentclass{standalone}\n")
+	}
+
+	if driver != "" {
+		fmt.Fprintf(&b, "\\def\\pgfsysdriver{%s}\n", driver)
+	}
+
 	b.WriteString("\\usepackage{amsmath}\n")
 	b.WriteString("\\usepackage{tikz}\n")
 	b.WriteString("\\usepackage{pgfplots}\n")
 	b.WriteString("\\usepackage{graphicx}\n")
 	b.WriteString("\\usepackage{xcolor}\n")
-	b.WriteString("\\begin{document}")
+
+	b.WriteString(joinLines(preamble))
+
+	// Place [tex] on its own lines so that errors can be traced back to it. The
+	// comments prevent the line breaks from introducing spurious whitespace.
+	b.WriteString(beginDocument)
 	b.WriteString(tex)
-	b.WriteString("\\end{document}")
+	b.WriteString("%\n\\end{document}\n")
 
 	return b.String()
 }
 
-func createSVG(dir string) error {
-	pdf2svg, err := exec.LookPath("pdf2svg")
-	if err != nil {
-		return err
-	}
+// Determine the line of [doc] on which the content of the chunk begins.
+func bodyLine(doc string) int {
+	i := strings.Index(doc, beginDocument)
+
+	return strings.Count(doc[:i+len(beginDocument)], "\n") + 1
+}
 
-	cmd := exec.Command(pdf2svg, filepath.Join(dir, "texput.pdf"), filepath.Join(dir, "texput.svg"))
+// Identify the engine found at [path]. The version participates in the cache
+// key, as upgrading TeX may change the rendered output.
+func engineVersion(path string) string {
+	if v, ok := versions.Load(path); ok {
+		return v.(string)
+	}
 
-	_, err = cmd.CombinedOutput()
+	out, err := exec.Command(path, "--version").Output()
 	if err != nil {
-		return err
+		logger.Error("Unable to determine version of %s: %s", path, err)
 	}
 
-	return nil
+	// Only the first line is interesting; the rest is licensing information.
+	v, _, _ := strings.Cut(string(out), "\n")
+	v = path + " " + v
+
+	versions.Store(path, v)
+
+	return v
 }
 
-func createPDF(tex, dir string) error {
-	pdflatex, err := exec.LookPath("pdflatex")
-	if err != nil {
+// Create a command running [name] within [dir], which is killed along with
+// its descendants once [ctx] is done.
+func command(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+
+	setProcessGroup(cmd)
+
+	// Don't wait on output held open by a process which survived cancellation.
+	cmd.WaitDelay = time.Second
+
+	return cmd
+}
+
+// Compile [doc] within [dir] with the engine at [engine], passing it any
+// additional [args]. If TeX fails, the error is extracted from its log and
+// reported as a *TexError against [tex].
+//
+// Every engine names its output (e.g. texput.pdf) and log after the job, and
+// reports errors in the same format given -file-line-error.
+func compile(ctx context.Context, doc, tex, dir, engine string, args ...string) error {
+	if err := os.WriteFile(filepath.Join(dir, "texput.tex"), []byte(doc), 0o644); err != nil {
 		return err
 	}
 
-	// TeX vomits out too much error output to reasonably convert into a Go error
-	// here. Additionally, errors are reported on STDOUT. Attempting to convert a
-	// missing file into an SVG will have to suffice as far for error reporting.
-	cmd := exec.Command(pdflatex, "-file-line-error", "-output-directory", dir)
+	// Errors are reported on STDOUT, but the log is more complete and doesn't
+	// interleave with anything else, so we parse that instead.
+	args = append([]string{"-file-line-error", "-interaction=nonstopmode", "-halt-on-error"}, args...)
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return err
+	out, err := command(ctx, dir, engine, append(args, "texput.tex")...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	// Killed, rather than failed.
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	if err := cmd.Start(); err != nil {
+	// Failed to run TeX at all.
+	if _, ok := err.(*exec.ExitError); !ok {
 		return err
 	}
 
-	go func() {
-		fmt.Fprintln(stdin, texDoc(tex))
-		stdin.Close()
-	}()
+	log, rerr := os.ReadFile(filepath.Join(dir, "texput.log"))
+	if rerr != nil {
+		// Without a log, the output is the best we can do.
+		log = out
+	}
+
+	if texErr := parseLog(string(log), tex, bodyLine(doc)); texErr != nil {
+		return texErr
+	}
 
-	return cmd.Wait()
+	return &TexError{Message: err.Error(), RawLog: string(log)}
 }
 
-func render(tex string) (string, error) {
-	tmp, err := os.MkdirTemp("", "tex")
+// Typeset [body] in a document of [class] and convert it to an SVG. Errors are
+// reported against [tex], the LaTeX written by the author, which [body] sets on
+// the same lines.
+func render(ctx context.Context, class, body, tex string, opts Options) (string, error) {
+	engine, err := ParseEngine(string(opts.Engine))
 	if err != nil {
 		return "", err
 	}
 
-	if err := createPDF(tex, tmp); err != nil {
+	enginePath, err := exec.LookPath(string(engine))
+	if err != nil {
 		return "", err
 	}
 
-	if err := createSVG(tmp); err != nil {
+	conv, err := newConverter(opts)
+	if err != nil {
 		return "", err
 	}
 
-	svg, err := os.ReadFile(filepath.Join(tmp, "texput.svg"))
+	doc := texDoc(class, conv.pgfDriver(engine), joinLines(opts.Preamble, opts.Macros), body)
+
+	var key string
+	if opts.Cache != nil {
+		key = cacheKey(engineVersion(enginePath), doc, conv.key())
+
+		if svg, ok := opts.Cache.Get(key); ok {
+			return svg, nil
+		}
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	tmp, err := os.MkdirTemp("", "tex")
 	if err != nil {
 		return "", err
 	}
+	defer os.RemoveAll(tmp)
+
+	if err := compile(ctx, doc, tex, tmp, enginePath, conv.engineArgs(engine)...); err != nil {
+		return "", timedOut(err, opts.Timeout)
+	}
+
+	if err := conv.convert(ctx, tmp, engine); err != nil {
+		return "", timedOut(err, opts.Timeout)
+	}
+
+	out, err := os.ReadFile(filepath.Join(tmp, "texput.svg"))
+	if err != nil {
+		return "", err
+	}
+
+	svg := string(out)
+
+	// Measured content is aligned with the text surrounding it.
+	if log, err := os.ReadFile(filepath.Join(tmp, "texput.log")); err == nil {
+		if m, ok := parseMetrics(string(log)); ok {
+			svg = align(svg, m)
+		}
+	}
+
+	if opts.Cache != nil {
+		// A cache failure only costs us a future render, so don't fail this one.
+		if err := opts.Cache.Put(key, svg); err != nil {
+			logger.Error("Unable to cache SVG: %s", err)
+		}
+	}
+
+	return svg, nil
+}
+
+// Describe a render which exceeded its [timeout], which is likelier the fault of
+// the TeX (e.g. an unbounded TikZ loop) than of the machine.
+func timedOut(err error, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("texrender: killed after %s: %w", timeout, err)
+	}
+
+	return err
+}
 
-	return string(svg), nil
+// RenderBlock accepts a block of [tex] and produces a corresponding SVG. If TeX
+// rejects [tex], the returned error is a *TexError. If [ctx] is done first, TeX
+// is killed and the returned error is that of [ctx].
+func RenderBlock(ctx context.Context, tex string, opts Options) (string, error) {
+	return render(ctx, "", tex, tex, opts)
 }
 
-// RenderBlock accepts a block of [tex] and produces a corresponding SVG.
-func RenderBlock(tex string) (string, error) {
-	return render(tex)
+// RenderInline accepts inline [tex] and produces a corresponding SVG. The SVG is
+// sized in ems and aligned such that it sits on the baseline of the text around
+// it. If TeX rejects [tex], the returned error is a *TexError.
+func RenderInline(ctx context.Context, tex string, opts Options) (string, error) {
+	return render(ctx, "", measure(tex), tex, opts)
 }
 
-// RenderInline accepts inline [tex] and produces a corresponding SVG.
-func RenderInline(tex string) (string, error) {
-	return render(fmt.Sprintf("$%s$", tex))
+// RenderEnvironment accepts a complete LaTeX environment such as
+// \begin{align}...\end{align} and produces a corresponding SVG. Display
+// environments are only permitted within a paragraph, so the document is set in
+// varwidth mode. If TeX rejects [tex], the returned error is a *TexError.
+func RenderEnvironment(ctx context.Context, tex string, opts Options) (string, error) {
+	return render(ctx, "varwidth", tex, tex, opts)
 }
@@ -0,0 +1,132 @@
+package texrender
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLine(t *testing.T) {
+	for _, preamble := range []string{"", "\\usepackage{physics}", "\\usepackage{physics}\n\\newcommand{\\R}{\\mathbb{R}}\n"} {
+		doc := texDoc("varwidth", "pgfsys-dvisvgm.def", preamble, "x + y = z")
+		lines := strings.Split(doc, "\n")
+
+		if n := bodyLine(doc); lines[n-1] != "x + y = z%" {
+			t.Errorf("Expected chunk on line %d, found %q", n, lines[n-1])
+		}
+	}
+}
+
+func TestParseLog(t *testing.T) {
+	tex := "\\begin{tikzpicture}\n\\draw (0,0) -- (1,1);\n\\drw (1,1) -- (2,0);\n\\end{tikzpicture}"
+
+	t.Run("FileLine", func(t *testing.T) {
+		log := strings.Join([]string{
+			"This is pdfTeX, Version 3.141592653-2.6-1.40.25 (TeX Live 2023) (preloaded format=pdflatex)",
+			"(./texput.tex",
+			"LaTeX2e <2022-11-01> patch level 1",
+			"./texput.tex:10: Undefined control sequence.",
+			"l.10 \\drw",
+			"           (1,1) -- (2,0);",
+		}, "\n")
+
+		err := parseLog(log, tex, 8)
+		if err == nil {
+			t.Fatal("Expected error")
+		}
+
+		if err.Message != "Undefined control sequence." {
+			t.Errorf("Unexpected message: %q", err.Message)
+		}
+
+		if err.Line != 3 || err.Context != "\\drw (1,1) -- (2,0);" {
+			t.Errorf("Expected line 3 (%q), got %d (%q)", "\\drw (1,1) -- (2,0);", err.Line, err.Context)
+		}
+
+		if err.RawLog != log {
+			t.Errorf("Raw log not preserved")
+		}
+	})
+
+	t.Run("Bang", func(t *testing.T) {
+		log := "! Undefined control sequence.\nl.9 \\draw (0,0) -- (1,1)\n"
+
+		err := parseLog(log, tex, 8)
+		if err == nil {
+			t.Fatal("Expected error")
+		}
+
+		if err.Line != 2 {
+			t.Errorf("Expected line 2, got %d", err.Line)
+		}
+	})
+
+	t.Run("Preamble", func(t *testing.T) {
+		log := "./texput.tex:3: LaTeX Error: File `nonexistent.sty' not found."
+
+		err := parseLog(log, tex, 8)
+		if err == nil {
+			t.Fatal("Expected error")
+		}
+
+		if err.Line != 0 || err.Context != "" {
+			t.Errorf("Preamble error attributed to line %d of chunk", err.Line)
+		}
+	})
+
+	t.Run("Clean", func(t *testing.T) {
+		if err := parseLog("Output written on texput.pdf (1 page, 1234 bytes).", tex, 8); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	})
+}
+
+func TestRenderBlock(t *testing.T) {
+	for _, bin := range []string{"pdflatex", "pdf2svg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		_, err := RenderBlock(context.Background(), "$x$\n$\\undefinedmacro$", Options{})
+
+		var texErr *TexError
+		if !errors.As(err, &texErr) {
+			t.Fatalf("Expected *TexError, got %v", err)
+		}
+
+		if texErr.Line != 2 {
+			t.Errorf("Expected error on line 2, got %d", texErr.Line)
+		}
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		_, err := RenderBlock(context.Background(), "\\def\\loop{\\loop}\\loop", Options{Timeout: time.Second})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline to be exceeded, got %v", err)
+		}
+	})
+}
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The grandchild holds the output open, so would outlive its parent (until
+	// WaitDelay) unless the whole process group is killed.
+	start := time.Now()
+	if _, err := command(ctx, t.TempDir(), "sh", "-c", "sleep 30; echo").CombinedOutput(); err == nil {
+		t.Fatal("Expected command to be killed")
+	}
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected command to be killed promptly, took %s", elapsed)
+	}
+}
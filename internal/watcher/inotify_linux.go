@@ -0,0 +1,182 @@
+package watcher
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// inotify watches every directory in the tree, adding watches for directories
+// as they are created.
+type inotify struct {
+	fd   int
+	file *os.File
+
+	mu    sync.Mutex
+	paths map[int32]string // Watched directories, keyed by watch descriptor.
+}
+
+func newInotify(root string, w *Watcher) (*inotify, error) {
+	// The descriptor must be non-blocking in order for reads to go through the
+	// runtime's poller, which allows Close to interrupt a pending read.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	in := &inotify{
+		fd:    fd,
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		paths: make(map[int32]string),
+	}
+
+	if err := in.addTree(root, w, false); err != nil {
+		in.file.Close()
+
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go in.run(w)
+
+	return in, nil
+}
+
+// Watch [root] and every directory beneath it. If [announce] is set, a Create
+// event is sent for every entry found, as they may have been created before the
+// watch was in place.
+func (in *inotify) addTree(root string, w *Watcher, announce bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may have been removed again before we got to it.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if path != root {
+			if w.ignored(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if announce && !w.send(Event{path, Create}) {
+				return filepath.SkipAll
+			}
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(in.fd, path, watchMask)
+		if err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				return nil
+			}
+
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+
+		in.mu.Lock()
+		in.paths[int32(wd)] = path
+		in.mu.Unlock()
+
+		return nil
+	})
+}
+
+func (in *inotify) run(w *Watcher) {
+	defer w.wg.Done()
+
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+
+	for {
+		n, err := in.file.Read(buf[:])
+		if err != nil {
+			// Closed by Watcher.Close
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+
+			if !w.fail(err) {
+				return
+			}
+
+			continue
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+
+			name := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if !in.handle(w, raw.Wd, raw.Mask, strings.TrimRight(string(name), "\x00")) {
+				return
+			}
+		}
+	}
+}
+
+// Translate a single inotify event. Reports false once the Watcher is closed.
+func (in *inotify) handle(w *Watcher, wd int32, mask uint32, name string) bool {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return w.fail(errors.New("watcher: inotify queue overflowed, events were lost"))
+	}
+
+	in.mu.Lock()
+	dir, ok := in.paths[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(in.paths, wd)
+	}
+	in.mu.Unlock()
+
+	// Events for the watched directory itself are reported by its parent.
+	if !ok || name == "" {
+		return true
+	}
+
+	path := filepath.Join(dir, name)
+
+	switch {
+	case mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		if !w.send(Event{path, Create}) {
+			return false
+		}
+
+		if !w.ignored(path) {
+			if err := in.addTree(path, w, true); err != nil {
+				return w.fail(err)
+			}
+		}
+
+		return true
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		return w.send(Event{path, Create})
+	case mask&(syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE) != 0:
+		return w.send(Event{path, Write})
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		return w.send(Event{path, Remove})
+	}
+
+	return true
+}
+
+func (in *inotify) close() error {
+	return in.file.Close()
+}
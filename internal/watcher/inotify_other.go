@@ -0,0 +1,15 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+type inotify struct{}
+
+func newInotify(root string, w *Watcher) (*inotify, error) {
+	return nil, errors.New("inotify is only available on Linux")
+}
+
+func (in *inotify) close() error {
+	return nil
+}
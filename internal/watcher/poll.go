@@ -0,0 +1,126 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// The attributes of a file used to detect changes while polling.
+type state struct {
+	mod  time.Time
+	size int64
+	dir  bool
+}
+
+// poller detects changes by periodically walking the tree and comparing the
+// modification times and sizes of its files against the previous scan.
+type poller struct {
+	root string
+	prev map[string]state
+}
+
+func newPoller(root string, w *Watcher) (*poller, error) {
+	p := &poller{root: root}
+
+	prev, err := p.scan(w)
+	if err != nil {
+		return nil, err
+	}
+
+	p.prev = prev
+
+	w.wg.Add(1)
+	go p.run(w)
+
+	return p, nil
+}
+
+func (p *poller) scan(w *Watcher) (map[string]state, error) {
+	files := make(map[string]state)
+
+	err := filepath.WalkDir(p.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files may disappear between reading a directory and visiting them.
+			if path != p.root {
+				return nil
+			}
+
+			return err
+		}
+
+		if w.ignored(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		files[path] = state{fi.ModTime(), fi.Size(), d.IsDir()}
+
+		return nil
+	})
+
+	return files, err
+}
+
+func (p *poller) run(w *Watcher) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		next, err := p.scan(w)
+		if err != nil {
+			if !w.fail(err) {
+				return
+			}
+
+			continue
+		}
+
+		for path, s := range next {
+			prev, ok := p.prev[path]
+
+			switch {
+			case !ok:
+				if !w.send(Event{path, Create}) {
+					return
+				}
+			// Directory timestamps change whenever their entries do, which are
+			// reported individually.
+			case !s.dir && (prev.mod != s.mod || prev.size != s.size):
+				if !w.send(Event{path, Write}) {
+					return
+				}
+			}
+		}
+
+		for path := range p.prev {
+			if _, ok := next[path]; !ok {
+				if !w.send(Event{path, Remove}) {
+					return
+				}
+			}
+		}
+
+		p.prev = next
+	}
+}
+
+func (p *poller) close() error {
+	return nil
+}
@@ -1 +1,253 @@
+// package watcher recursively watches a directory tree for changes to files.
+//
+// Editors rarely write a file in a single operation: most save by writing to a
+// temporary or swap file before renaming it over the original, and may touch
+// the file several times in quick succession. The watcher coalesces such bursts
+// into a single batch of events describing the net change to each file, and
+// ignores the temporary files entirely.
+//
+// On Linux, changes are detected with inotify. Elsewhere (or if inotify is not
+// available) the watcher falls back to periodically polling the tree.
 package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beautifultovarisch/webtex/internal/ignore"
+	"github.com/beautifultovarisch/webtex/internal/logger"
+)
+
+// Op describes the net change to a file.
+type Op uint8
+
+const (
+	Create Op = iota + 1
+	Write
+	Remove
+)
+
+func (o Op) String() string {
+	ops := map[Op]string{
+		Create: "Create",
+		Write:  "Write",
+		Remove: "Remove",
+	}
+
+	return ops[o]
+}
+
+// Event reports a change to the file at Path.
+type Event struct {
+	Path string
+	Op   Op
+}
+
+// Options configure a Watcher. The zero value is ready to use.
+type Options struct {
+	Debounce time.Duration          // Debounce is the quiet period awaited before a batch is emitted. Defaults to 100ms.
+	Interval time.Duration          // Interval is the period between scans when polling. Defaults to 500ms.
+	Poll     bool                   // Poll forces polling, even when inotify is available.
+	Ignore   func(path string) bool // Ignore reports whether changes to [path] should be dropped, in addition to temporary files.
+}
+
+// Watcher watches a directory tree, delivering batches of changes on Events.
+// Both Events and Errors must be drained by the caller.
+type Watcher struct {
+	Events chan []Event // Events receives the net changes of each burst of activity.
+	Errors chan error   // Errors receives errors that do not stop the Watcher.
+
+	opts Options
+	raw  chan Event // Uncoalesced events produced by the backend.
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	backend interface{ close() error }
+}
+
+// ErrClosed is returned when closing a Watcher more than once.
+var ErrClosed = errors.New("watcher: already closed")
+
+// Temporary files written by editors while saving, as matched by the same
+// patterns which builds ignore.
+var temporary = ignore.New(ignore.Temporary...)
+
+func isTemp(path string) bool {
+	return temporary.Ignored(filepath.Base(path), false)
+}
+
+// New starts watching the directory tree rooted at [root].
+func New(root string, opts Options) (*Watcher, error) {
+	if opts.Debounce <= 0 {
+		opts.Debounce = 100 * time.Millisecond
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = 500 * time.Millisecond
+	}
+
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return nil, &os.PathError{Op: "watch", Path: root, Err: errors.New("not a directory")}
+	}
+
+	w := &Watcher{
+		Events: make(chan []Event),
+		Errors: make(chan error),
+		opts:   opts,
+		raw:    make(chan Event, 64),
+		done:   make(chan struct{}),
+	}
+
+	if !opts.Poll {
+		if in, err := newInotify(root, w); err != nil {
+			logger.Error("Unable to use inotify, falling back to polling: %s", err)
+		} else {
+			w.backend = in
+		}
+	}
+
+	if w.backend == nil {
+		p, err := newPoller(root, w)
+		if err != nil {
+			return nil, err
+		}
+
+		w.backend = p
+	}
+
+	w.wg.Add(1)
+	go w.coalesce()
+
+	return w, nil
+}
+
+// Close stops the Watcher and closes the Events and Errors channels.
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+		return ErrClosed
+	default:
+	}
+
+	close(w.done)
+
+	err := w.backend.close()
+
+	w.wg.Wait()
+
+	close(w.Events)
+	close(w.Errors)
+
+	return err
+}
+
+func (w *Watcher) ignored(path string) bool {
+	return isTemp(path) || (w.opts.Ignore != nil && w.opts.Ignore(path))
+}
+
+// Used by the backends to deliver an event. Reports false once the Watcher has
+// been closed.
+func (w *Watcher) send(e Event) bool {
+	if w.ignored(e.Path) {
+		return true
+	}
+
+	select {
+	case w.raw <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// Used by the backends to deliver an error. Reports false once the Watcher has
+// been closed.
+func (w *Watcher) fail(err error) bool {
+	select {
+	case w.Errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// Determine the net effect of a burst of changes on [path], given the [first]
+// operation observed within the burst.
+func netOp(path string, first Op) (Op, bool) {
+	_, err := os.Lstat(path)
+	exists := err == nil
+
+	switch {
+	// Created and removed again, e.g a temporary file we didn't recognize.
+	case !exists && first == Create:
+		return 0, false
+	case !exists:
+		return Remove, true
+	case first == Create:
+		return Create, true
+	default:
+		// Includes files which were removed and replaced, as editors do.
+		return Write, true
+	}
+}
+
+// Collect raw events until no more have arrived for the debounce period, then
+// emit their net effect as a single batch.
+func (w *Watcher) coalesce() {
+	defer w.wg.Done()
+
+	pending := make(map[string]Op)
+
+	timer := time.NewTimer(w.opts.Debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			timer.Stop()
+
+			return
+
+		case e := <-w.raw:
+			if _, ok := pending[e.Path]; !ok {
+				pending[e.Path] = e.Op
+			}
+
+			timer.Reset(w.opts.Debounce)
+
+		case <-timer.C:
+			var batch []Event
+
+			for path, first := range pending {
+				if op, ok := netOp(path, first); ok {
+					batch = append(batch, Event{path, op})
+				}
+			}
+
+			pending = make(map[string]Op)
+
+			if len(batch) == 0 {
+				continue
+			}
+
+			sort.Slice(batch, func(i, j int) bool {
+				return batch[i].Path < batch[j].Path
+			})
+
+			select {
+			case w.Events <- batch:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
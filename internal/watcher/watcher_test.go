@@ -0,0 +1,207 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Wait for the next batch of events, failing the test if none arrives.
+func nextBatch(w *Watcher, t *testing.T) []Event {
+	t.Helper()
+
+	select {
+	case batch := <-w.Events:
+		return batch
+	case err := <-w.Errors:
+		t.Fatalf("Watcher error: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for events")
+	}
+
+	return nil
+}
+
+// Ensure no batch arrives within a short period.
+func noBatch(w *Watcher, t *testing.T) {
+	t.Helper()
+
+	select {
+	case batch := <-w.Events:
+		t.Errorf("Unexpected events: %v", batch)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func cmpBatch(expected, actual []Event, t *testing.T) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("Expected: %v\n\nActual: %v", expected, actual)
+	}
+
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("Expected: %v\n\nActual: %v", expected, actual)
+		}
+	}
+}
+
+func write(path, content string, t *testing.T) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testWatcher(opts Options, t *testing.T) {
+	opts.Debounce = 50 * time.Millisecond
+	opts.Interval = 20 * time.Millisecond
+
+	start := func(t *testing.T) (*Watcher, string) {
+		dir := t.TempDir()
+
+		write(filepath.Join(dir, "existing.md"), "# Existing", t)
+
+		w, err := New(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { w.Close() })
+
+		return w, dir
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		w, dir := start(t)
+
+		path := filepath.Join(dir, "new.md")
+		write(path, "# New", t)
+
+		cmpBatch([]Event{{path, Create}}, nextBatch(w, t), t)
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		w, dir := start(t)
+
+		path := filepath.Join(dir, "existing.md")
+		write(path, "# Modified", t)
+
+		cmpBatch([]Event{{path, Write}}, nextBatch(w, t), t)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		w, dir := start(t)
+
+		path := filepath.Join(dir, "existing.md")
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		cmpBatch([]Event{{path, Remove}}, nextBatch(w, t), t)
+	})
+
+	t.Run("Coalesce", func(t *testing.T) {
+		w, dir := start(t)
+
+		path := filepath.Join(dir, "existing.md")
+
+		// Save the way vim does: write a swap file, move the original aside and
+		// rename the new contents into place.
+		swap := filepath.Join(dir, ".existing.md.swp")
+		write(swap, "swap", t)
+		write(filepath.Join(dir, "4913"), "", t)
+		os.Remove(filepath.Join(dir, "4913"))
+		os.Rename(path, path+"~")
+		write(path, "# Saved", t)
+		os.Remove(path + "~")
+		os.Remove(swap)
+
+		cmpBatch([]Event{{path, Write}}, nextBatch(w, t), t)
+		noBatch(w, t)
+	})
+
+	t.Run("Ignore", func(t *testing.T) {
+		opts := opts
+		opts.Ignore = func(path string) bool {
+			return filepath.Ext(path) == ".draft"
+		}
+
+		dir := t.TempDir()
+
+		w, err := New(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		write(filepath.Join(dir, "notes.draft"), "", t)
+		write(filepath.Join(dir, "notes.md~"), "", t)
+
+		noBatch(w, t)
+	})
+
+	t.Run("Subdirectory", func(t *testing.T) {
+		w, dir := start(t)
+
+		sub := filepath.Join(dir, "Calculus", "Integration")
+		if err := os.MkdirAll(sub, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(sub, "Step Functions.md")
+		write(path, "# Step Functions", t)
+
+		cmpBatch([]Event{
+			{filepath.Join(dir, "Calculus"), Create},
+			{sub, Create},
+			{path, Create},
+		}, nextBatch(w, t), t)
+
+		// Changes within the new directory are also detected.
+		write(path, "# Step Functions\n\n$$x$$", t)
+
+		cmpBatch([]Event{{path, Write}}, nextBatch(w, t), t)
+	})
+}
+
+func TestWatcher(t *testing.T) {
+	t.Run("Native", func(t *testing.T) {
+		testWatcher(Options{}, t)
+	})
+
+	t.Run("Poll", func(t *testing.T) {
+		testWatcher(Options{Poll: true}, t)
+	})
+
+	t.Run("NotDirectory", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file.md")
+		write(path, "", t)
+
+		if _, err := New(path, Options{}); err == nil {
+			t.Errorf("Expected error watching a regular file")
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		w, err := New(t.TempDir(), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+
+		if _, ok := <-w.Events; ok {
+			t.Errorf("Events not closed")
+		}
+
+		if err := w.Close(); err != ErrClosed {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	})
+}
@@ -2,18 +2,61 @@
 package build
 
 import (
-	// "io"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/beautifultovarisch/webtex/pkg/render"
 
+	"github.com/beautifultovarisch/webtex/internal/ignore"
+	"github.com/beautifultovarisch/webtex/internal/logger"
 	"github.com/beautifultovarisch/webtex/internal/sitebuilder"
 )
 
+// Options configure a build. The zero value is ready to use.
+type Options struct {
+	Render     render.Options // Render configures the rendering of each document.
+	LiveReload string         // LiveReload is the URL of a development server's reload events, if any.
+	Force      bool           // Force renders every document, even those recorded as unchanged in the ManifestFile.
+	Workers    int            // Workers bounds the number of documents rendered concurrently. Defaults to the number of CPUs.
+	PrettyURLs bool           // PrettyURLs writes each page as the index of its own directory, e.g. notes/index.html.
+	Link       bool           // Link hard-links assets into the output directory rather than copying them, where possible.
+
+	// Include and Exclude select the assets (i.e. files other than markdown
+	// documents) to copy. An asset is copied if it matches any pattern of
+	// Include, or Include is empty, and no pattern of Exclude. Patterns are
+	// matched as by path.Match against the asset's slash separated path
+	// relative to the source directory, or only its name if the pattern
+	// contains no slash, e.g. "*.png" or "drafts/*".
+	Include []string
+	Exclude []string
+}
+
+// Files at the root of the source directory configuring the LaTeX of every
+// formula in the site. They are not themselves documents.
+const (
+	PreambleFile = "preamble.tex" // PreambleFile is included in the preamble of every formula.
+	MacrosFile   = "macros.tex"   // MacrosFile defines macros, e.g. \newcommand{\R}{\mathbb{R}}, for every formula.
+)
+
+// Reports whether [path] is one of the files configuring the LaTeX of the site
+// beneath [src].
+func isSiteTex(src, path string) bool {
+	path = filepath.Clean(path)
+
+	return path == filepath.Join(src, PreambleFile) || path == filepath.Join(src, MacrosFile)
+}
+
 // Nav is an adjacency list of the file organization of markdown files. Entries
 // are represented as [os.DirEntry] for convenience.
 type Nav map[string][]os.DirEntry
@@ -22,9 +65,24 @@ type Nav map[string][]os.DirEntry
 // source files located under [file]. This information is useful for generating
 // a global site map and navigation components.
 //
+// Files ignored by the ignore.File in [file] (or by ignore.Defaults) are left
+// out.
+func SiteNav(file string) (Nav, error) {
+	var ig *ignore.Matcher
+
+	// Only a directory may hold an ignore file.
+	if stat, err := os.Stat(file); err == nil && stat.IsDir() {
+		if ig, err = ignore.Load(file); err != nil {
+			return Nav{}, err
+		}
+	}
+
+	return siteNav(file, file, ig)
+}
+
 // TODO: Optimize by eliminating the call to os.Stat and traversing on DirEntry
 // types instead.
-func SiteNav(file string) (Nav, error) {
+func siteNav(root, file string, ig *ignore.Matcher) (Nav, error) {
 	stat, err := os.Stat(file)
 	if err != nil {
 		return Nav{}, err
@@ -35,16 +93,23 @@ func SiteNav(file string) (Nav, error) {
 		return Nav{}, nil
 	}
 
-	files, err := os.ReadDir(file)
+	entries, err := os.ReadDir(file)
 	if err != nil {
 		return Nav{}, err
 	}
 
+	var files []os.DirEntry
+	for _, f := range entries {
+		if rel, err := filepath.Rel(root, filepath.Join(file, f.Name())); err == nil && !ig.Ignored(rel, f.IsDir()) {
+			files = append(files, f)
+		}
+	}
+
 	nav := make(Nav)
 	nav[file] = files
 
 	for _, f := range files {
-		tree, err := SiteNav(filepath.Join(file, f.Name()))
+		tree, err := siteNav(root, filepath.Join(file, f.Name()), ig)
 		if err != nil {
 			return Nav{}, err
 		}
@@ -55,73 +120,594 @@ func SiteNav(file string) (Nav, error) {
 	return nav, nil
 }
 
-// Strip the source file of its extension and produce a path with the desired
-// output extension.
-func outputPath(path string) string {
-	// Should only ever be one
-	return strings.Replace(path, ".md", ".html", 1)
+// Append the contents of the file at [path], if it exists, to [tex].
+func appendFile(tex *string, path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if *tex != "" && !strings.HasSuffix(*tex, "\n") {
+		*tex += "\n"
+	}
+
+	*tex += string(b)
+
+	return nil
 }
 
-func processDir(src, dst string) error {
-	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() && path != src {
-			// Mirror the directory structure of the source files.
-			if err := os.MkdirAll(filepath.Join(dst, path), os.ModePerm); err != nil {
-				return err
-			}
+// Extend the TeX preamble and macros of [opts] with those of the site beneath
+// [src], if it has any.
+func siteTex(src string, opts Options) (Options, error) {
+	if err := appendFile(&opts.Render.Tex.Preamble, filepath.Join(src, PreambleFile)); err != nil {
+		return opts, err
+	}
 
-			return nil
+	if err := appendFile(&opts.Render.Tex.Macros, filepath.Join(src, MacrosFile)); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// site is the state shared by every document of a build.
+type site struct {
+	src, dst string
+	out      string // The output directory relative to src, if within it.
+	opts     Options
+	ignore   *ignore.Matcher
+	r        *render.Renderer
+	m        *manifest
+	routes   routes
+}
+
+// Prepare to build the sources at [paths] beneath [src] into [dst], ignoring
+// those matched by [ig]. Sources which can't be routed to an output of their
+// own are reported and left out of the routes.
+func newSite(src, dst string, paths []string, ig *ignore.Matcher, opts Options) (*site, error) {
+	rels := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if rel, err := filepath.Rel(src, path); err == nil {
+			rels = append(rels, rel)
+		}
+	}
+
+	routes, err := newRoutes(rels, opts.PrettyURLs)
+
+	return &site{
+		src:    src,
+		dst:    dst,
+		out:    outputDir(src, dst),
+		opts:   opts,
+		ignore: ig,
+		// Share a single renderer so that TeX concurrency is bounded across
+		// documents.
+		r:      render.New(opts.Render),
+		m:      loadManifest(dst, configHash(opts)),
+		routes: routes,
+	}, err
+}
+
+// Reports whether the outputs of the source at [rel], as recorded by the
+// manifest, should be removed: the source no longer exists, is ignored, is an
+// asset which is no longer selected, or is itself output.
+func (s *site) stale(rel string) bool {
+	fi, err := os.Stat(filepath.Join(s.src, rel))
+	if errors.Is(err, fs.ErrNotExist) || beneath(rel, s.out) {
+		return true
+	}
+
+	if err != nil {
+		return false
+	}
+
+	return s.ignore.Ignored(rel, fi.IsDir()) || (!isDocument(rel) && !selected(rel, s.opts))
+}
+
+// Reports whether the source at [path] has an output.
+func (s *site) routed(path string) bool {
+	rel, err := filepath.Rel(s.src, path)
+
+	return err == nil && s.routes[rel] != ""
+}
+
+// Match the slash separated path [rel] against [patterns], as described by
+// Options.Include.
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = path.Base(rel)
 		}
 
-		fi, err := d.Info()
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the asset at [rel], relative to the source directory, is
+// selected by the Include and Exclude patterns of [opts].
+func selected(rel string, opts Options) bool {
+	rel = filepath.ToSlash(rel)
+
+	if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+		return false
+	}
+
+	return !matchAny(opts.Exclude, rel)
+}
+
+// Determine the path of [dst] relative to [src] if it lies within [src], e.g.
+// "_site", or "" otherwise.
+func outputDir(src, dst string) string {
+	asrc, err := filepath.Abs(src)
+	if err != nil {
+		return ""
+	}
+
+	adst, err := filepath.Abs(dst)
+	if err != nil {
+		return ""
+	}
+
+	rel, err := filepath.Rel(asrc, adst)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return ""
+	}
+
+	return rel
+}
+
+// Reports whether [rel] is the directory [dir], or lies beneath it.
+func beneath(rel, dir string) bool {
+	return dir != "" && (rel == dir || strings.HasPrefix(rel, dir+string(filepath.Separator)))
+}
+
+// Find the paths of the sources beneath [src]: every markdown document, and
+// every asset selected by [opts], unless ignored by [ig]. The output directory
+// [dst] is skipped should it lie within [src], so that earlier output isn't
+// copied into the site. Whatever can't be read is reported, but doesn't stop
+// the search.
+func sources(ctx context.Context, src, dst string, ig *ignore.Matcher, opts Options) ([]string, error) {
+	var (
+		paths []string
+		errs  []error
+	)
+
+	out := outputDir(src, dst)
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			errs = append(errs, err)
+
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		if fi.Mode().IsRegular() {
-			content, err := os.ReadFile(path)
-			if err != nil {
-				return err
-			}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
 
-			doc := sitebuilder.Document{
-				Title:   d.Name(),
-				Content: render.RenderDoc(string(content)),
+		if ig.Ignored(rel, d.IsDir()) || beneath(rel, out) {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
 
-			// Output file.
-			file, err := os.Create(filepath.Join(dst, outputPath(path)))
-			defer file.Close()
+			return nil
+		}
 
-			if err != nil {
-				return err
-			}
+		if !d.Type().IsRegular() || isSiteTex(src, path) {
+			return nil
+		}
 
-			if err := sitebuilder.HTMLDoc(file, doc); err != nil {
-				return err
-			}
+		if isDocument(path) || selected(rel, opts) {
+			paths = append(paths, path)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, errors.Join(errs...)
+}
+
+// Render the markdown document at [path] into the page [out] beneath the
+// output directory, reporting the sources it links to as by resolveLinks.
+func (s *site) renderFile(ctx context.Context, path, rel, out string) (map[string]string, error) {
+	md, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer md.Close()
+
+	var content strings.Builder
+	if err := s.r.RenderDoc(ctx, md, &content); err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+
+	page, links := s.routes.resolveLinks(content.String(), rel, s.opts.PrettyURLs)
+
+	doc := sitebuilder.Document{
+		Title:      filepath.Base(path),
+		Content:    page,
+		LiveReload: s.opts.LiveReload,
+	}
+
+	out = filepath.Join(s.dst, out)
+	if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return links, sitebuilder.HTMLDoc(file, doc)
+}
+
+// Copy the asset at [path] to [out] beneath the output directory, or hard-link
+// it if Options.Link is set and the file system allows.
+func (s *site) copyFile(path, out string) error {
+	out = filepath.Join(s.dst, out)
+	if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+		return err
+	}
+
+	if s.opts.Link {
+		if err := os.Remove(out); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if err := os.Link(path, out); err == nil {
+			return nil
+		}
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename, rather than truncating [out], which
+	// may be a link to [path] made by an earlier build.
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return nil
+}
+
+// Render the document (or copy the asset) at [path] unless the manifest
+// records that its output is up to date, recording the output in the manifest
+// once built.
+func (s *site) buildFile(ctx context.Context, path string) error {
+	rel, err := filepath.Rel(s.src, path)
+	if err != nil {
+		return err
+	}
+
+	out, ok := s.routes[rel]
+	if !ok {
+		return fmt.Errorf("build: %s has no output", path)
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !s.opts.Force && s.m.fresh(rel, hash, s.dst, s.routes) {
+		logger.Log("Skipping unchanged %s", path)
+
+		return nil
+	}
+
+	// Until built, the output is known but not up to date. Any output built
+	// previously (e.g. before pretty URLs) is remembered until it is replaced.
+	prev := s.m.get(rel)
+
+	pending := prev.Outputs
+	if !slices.Contains(pending, out) {
+		pending = append(slices.Clip(pending), out)
+	}
+
+	s.m.set(rel, source{Outputs: pending})
+
+	var links map[string]string
+
+	if isDocument(path) {
+		links, err = s.renderFile(ctx, path, rel, out)
+	} else {
+		err = s.copyFile(path, out)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.m.set(rel, source{Hash: hash, Outputs: []string{out}, Links: links})
+
+	for _, o := range prev.Outputs {
+		if o == out {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dst, o)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Build the documents at [paths] concurrently, at most [opts].Workers at a
+// time. Every document is built, even if some fail; the errors are joined in
+// the order of [paths]. Once [ctx] is done, no more are started and only the
+// error of [ctx] is reported.
+func (s *site) buildFiles(ctx context.Context, paths []string) error {
+	workers := s.opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < min(workers, len(paths)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				errs[i] = s.buildFile(ctx, paths[i])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range paths {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
 }
 
 // Build reads the markdown files under the [src] directory and writes HTML to
-// the [dst] directory.
-func Build(src string, dst string) error {
+// the [dst] directory, copying any other files (assets, e.g. images) selected
+// by the Include and Exclude patterns of [opts] as they are. If [ctx] is done
+// first, the build stops, leaving any documents it has yet to render as they
+// were.
+//
+// Pages are written to the same paths relative to [dst] as their documents
+// relative to [src], with every element slugified, e.g. "Calculus/Exponents
+// and Logarithms.md" becomes calculus/exponents-and-logarithms.html (or with
+// PrettyURLs, calculus/exponents-and-logarithms/index.html). Assets keep their
+// names, but sit in the same directories as the pages beside them. Links
+// between documents, and to assets, are rewritten to link their outputs.
+//
+// Builds are incremental: the ManifestFile in [dst] records the content from
+// which each output was built, and the configuration of the build. Documents
+// are only rendered (and assets copied) if they or the configuration changed
+// since, or the sources they link to were added, removed or routed elsewhere.
+// The outputs of sources which no longer exist (or are excluded) are removed.
+func Build(ctx context.Context, src string, dst string, opts Options) error {
 	_, err := SiteNav(src)
 	if err != nil {
 		return err
 	}
 
+	opts, err = siteTex(src, opts)
+	if err != nil {
+		return err
+	}
+
 	// Create output directory
-	if err := os.MkdirAll(filepath.Join(dst, src), os.ModePerm); err != nil {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
 		return err
 	}
 
-	if err := processDir(src, dst); err != nil {
+	ig, err := ignore.Load(src)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	paths, err := sources(ctx, src, dst, ig, opts)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s, rerr := newSite(src, dst, paths, ig, opts)
+	err = errors.Join(err, rerr)
+
+	var routed []string
+	for _, path := range paths {
+		if s.routed(path) {
+			routed = append(routed, path)
+		}
+	}
+
+	err = errors.Join(err, s.buildFiles(ctx, routed))
+
+	for rel := range s.m.Sources {
+		if s.stale(rel) {
+			err = errors.Join(err, s.m.remove(rel, dst))
+		}
+	}
+
+	// Record progress even if the build failed, so that it isn't repeated.
+	return errors.Join(err, s.m.save(dst))
+}
+
+// Rebuild updates the [dst] directory previously populated by Build to reflect
+// changes to [paths] beneath the [src] directory, e.g. as reported by the
+// watcher. Modified documents and assets (or those within modified
+// directories) are built again and the outputs of removed ones (or
+// directories) are deleted. Pages linking to sources which were added or
+// removed are rewritten. Any other paths are ignored.
+//
+// A change to the site's PreambleFile or MacrosFile may affect any formula, and
+// a change to its ignore.File any source, so the whole site is built again.
+//
+// Every path is processed, even if some fail; the errors are joined together.
+// If [ctx] is done first, the rebuild stops and reports the error of [ctx],
+// e.g. so that it can be superseded by a rebuild of newer changes.
+func Rebuild(ctx context.Context, src, dst string, paths []string, opts Options) error {
+	for _, path := range paths {
+		if isSiteTex(src, path) || filepath.Clean(path) == filepath.Join(src, ignore.File) {
+			return Build(ctx, src, dst, opts)
+		}
+	}
+
+	opts, err := siteTex(src, opts)
+	if err != nil {
+		return err
+	}
+
+	ig, err := ignore.Load(src)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	// Every source is routed, as any may be linked to.
+	all, err := sources(ctx, src, dst, ig, opts)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	s, err := newSite(src, dst, all, ig, opts)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	var modified []string // Modified sources, built concurrently once the rest are processed.
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+
+			break
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil || !filepath.IsLocal(rel) || beneath(rel, s.out) {
+			continue
+		}
+
+		fi, err := os.Stat(path)
+
+		// Removed paths can't be told apart from directories, so are
+		// considered files; those within ignored directories are ignored
+		// either way.
+		if ig.Ignored(rel, err == nil && fi.IsDir()) {
+			continue
+		}
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// The path may have been a document, an asset or a directory. Only
+			// the outputs the manifest records are removed, as others (e.g.
+			// the page of a document named after a directory) may share its
+			// output path.
+			if err := s.m.remove(rel, dst); err != nil {
+				errs = append(errs, err)
+			}
+		case err != nil:
+			errs = append(errs, err)
+		case fi.IsDir():
+			for _, p := range all {
+				if strings.HasPrefix(p, path+string(filepath.Separator)) && s.routed(p) {
+					modified = append(modified, p)
+				}
+			}
+		case s.routed(path):
+			modified = append(modified, path)
+		}
+	}
+
+	// Pages linking to sources which were added or removed since must be
+	// rewritten too, even if their documents weren't modified.
+	for _, rel := range s.m.relinked(s.routes) {
+		path := filepath.Join(src, rel)
+
+		if s.routed(path) && !slices.ContainsFunc(modified, func(p string) bool { return filepath.Clean(p) == path }) {
+			modified = append(modified, path)
+		}
+	}
+
+	if err := s.buildFiles(ctx, modified); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := s.m.save(dst); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
@@ -1,9 +1,24 @@
 package build
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+// Building a site requires a working TeX installation.
+func requireTeX(t *testing.T) {
+	for _, bin := range []string{"pdflatex", "pdf2svg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+}
+
 func TestSiteNav(t *testing.T) {
 	t.Run("HappyPath", func(t *testing.T) {
 		if _, err := SiteNav("testdata/"); err != nil {
@@ -20,10 +35,12 @@ func TestSiteNav(t *testing.T) {
 }
 
 func TestBuild(t *testing.T) {
+	requireTeX(t)
+
 	t.Run("Single", func(t *testing.T) {
 		// tmp := t.TempDir()
 
-		if err := Build("testdata/single", "/tmp/_html"); err != nil {
+		if err := Build(context.Background(), "testdata/single", "/tmp/_html", Options{}); err != nil {
 			t.Errorf("Failed to build site: %s", err)
 		}
 	})
@@ -31,7 +48,7 @@ func TestBuild(t *testing.T) {
 	t.Run("Small", func(t *testing.T) {
 		tmp := t.TempDir()
 
-		if err := Build("testdata/Calculus/Exponents and Logarithms", tmp); err != nil {
+		if err := Build(context.Background(), "testdata/Calculus/Exponents and Logarithms", tmp, Options{}); err != nil {
 			t.Errorf("Failed to build site: %s", err)
 		}
 	})
@@ -39,7 +56,7 @@ func TestBuild(t *testing.T) {
 	t.Run("Medium", func(t *testing.T) {
 		tmp := t.TempDir()
 
-		if err := Build("testdata/Calculus/Integration", tmp); err != nil {
+		if err := Build(context.Background(), "testdata/Calculus/Integration", tmp, Options{}); err != nil {
 			t.Errorf("Failed to build site: %s", err)
 		}
 	})
@@ -47,8 +64,588 @@ func TestBuild(t *testing.T) {
 	t.Run("Big", func(t *testing.T) {
 		tmp := t.TempDir()
 
-		if err := Build("testdata/Calculus", tmp); err != nil {
+		if err := Build(context.Background(), "testdata/Calculus", tmp, Options{}); err != nil {
 			t.Errorf("Failed to build site: %s", err)
 		}
 	})
 }
+
+func TestRebuild(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	// Plain markdown doesn't require TeX to render.
+	path := filepath.Join(src, "notes.md")
+	if err := os.WriteFile(path, []byte("# Notes\n\nNo math here."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dst, outputPath(filepath.Base(path), false))
+
+	t.Run("Modified", func(t *testing.T) {
+		if err := Rebuild(context.Background(), src, dst, []string{path}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(out); err != nil {
+			t.Errorf("Output not rendered: %s", err)
+		}
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Rebuild(context.Background(), src, dst, []string{path}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("Output of removed document still exists")
+		}
+	})
+
+	t.Run("Outside", func(t *testing.T) {
+		if err := Rebuild(context.Background(), src, dst, []string{"testdata/single/Cheatsheet.md"}, Options{}); err != nil {
+			t.Error(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dst, "cheatsheet.html")); !os.IsNotExist(err) {
+			t.Errorf("Rendered document outside of source directory")
+		}
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		path := filepath.Join(src, "later.md")
+		if err := os.WriteFile(path, []byte("# Later"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := Rebuild(ctx, src, dst, []string{path}, Options{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected cancellation to be reported, got %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dst, outputPath(filepath.Base(path), false))); !os.IsNotExist(err) {
+			t.Errorf("Rendered document after cancellation")
+		}
+	})
+}
+
+func TestIncremental(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	// Plain markdown doesn't require TeX to render.
+	docs := map[string]string{"a.md": "# A", "b.md": "# B"}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := func(name string) string {
+		return filepath.Join(dst, outputPath(name, false))
+	}
+
+	// Mark each output, such that a render is detected by the mark's absence.
+	mark := func(t *testing.T) {
+		for name := range docs {
+			if err := os.WriteFile(output(name), []byte("marked"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	rendered := func(name string) bool {
+		b, err := os.ReadFile(output(name))
+
+		return err == nil && string(b) != "marked"
+	}
+
+	if err := Build(context.Background(), src, dst, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, ManifestFile)); err != nil {
+		t.Fatalf("Manifest not written: %s", err)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		mark(t)
+
+		if err := Build(context.Background(), src, dst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if rendered("a.md") || rendered("b.md") {
+			t.Errorf("Rendered unchanged documents")
+		}
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		mark(t)
+
+		if err := os.WriteFile(filepath.Join(src, "a.md"), []byte("# A, again"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Build(context.Background(), src, dst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !rendered("a.md") || rendered("b.md") {
+			t.Errorf("Expected only the modified document to be rendered")
+		}
+	})
+
+	t.Run("Relative", func(t *testing.T) {
+		// The same site, spelled relative to the working directory.
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsrc, err := filepath.Rel(wd, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rdst, err := filepath.Rel(wd, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mark(t)
+
+		if err := os.WriteFile(filepath.Join(src, "a.md"), []byte("# A, relative"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Build(context.Background(), rsrc, rdst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !rendered("a.md") || rendered("b.md") {
+			t.Errorf("Expected only the modified document to be rendered")
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		mark(t)
+
+		if err := Build(context.Background(), src, dst, Options{LiveReload: "/reload"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !rendered("a.md") || !rendered("b.md") {
+			t.Errorf("Expected every document to be rendered with a new configuration")
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		mark(t)
+
+		if err := Build(context.Background(), src, dst, Options{LiveReload: "/reload", Force: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !rendered("a.md") || !rendered("b.md") {
+			t.Errorf("Expected every document to be rendered when forced")
+		}
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(src, "b.md")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Build(context.Background(), src, dst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(output("b.md")); !os.IsNotExist(err) {
+			t.Errorf("Output of removed document still exists")
+		}
+	})
+}
+
+func TestRelink(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(src, "a.md"), []byte("[B](B%20Note.md)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(src, "B Note.md")
+
+	links := func(t *testing.T, href string) {
+		page, err := os.ReadFile(filepath.Join(dst, "a.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(page), `href="`+href+`"`) {
+			t.Errorf("Expected page to link %s:\n%s", href, page)
+		}
+	}
+
+	if err := Build(context.Background(), src, dst, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	links(t, "B%20Note.md")
+
+	t.Run("Added", func(t *testing.T) {
+		if err := os.WriteFile(target, []byte("# B"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Build(context.Background(), src, dst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		links(t, "b-note.html")
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		if err := os.Remove(target); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Rebuild(context.Background(), src, dst, []string{target}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		links(t, "B%20Note.md")
+	})
+
+	t.Run("Readded", func(t *testing.T) {
+		if err := os.WriteFile(target, []byte("# B"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Rebuild(context.Background(), src, dst, []string{target}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		links(t, "b-note.html")
+	})
+}
+
+func TestBuildErrors(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	// An unknown attribute fails without requiring TeX.
+	docs := map[string]string{
+		"bad1.md": "```tex size=large\nx\n```\n",
+		"bad2.md": "```tex size=large\nx\n```\n",
+		"good.md": "# Good",
+	}
+
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := Build(context.Background(), src, dst, Options{Workers: 2})
+	if err == nil {
+		t.Fatal("Expected build to fail")
+	}
+
+	for _, name := range []string{"bad1.md", "bad2.md"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error for %s, got: %s", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "good.html")); err != nil {
+		t.Errorf("Expected the valid document to be built: %s", err)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	files := map[string]string{
+		"note.md":         "# Note\n\n![plot](img/My%20Plot.png)\n",
+		"img/My Plot.png": "png",
+		"img/my-plot.png": "png",
+		"Note/b.md":       "# B",
+		"data.csv":        "x,y\n",
+		"drafts/old.png":  "png",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := Options{PrettyURLs: true, Link: true, Exclude: []string{"drafts/*"}}
+
+	if err := Build(context.Background(), src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Copied", func(t *testing.T) {
+		for _, name := range []string{"img/My Plot.png", "data.csv"} {
+			b, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+			if err != nil || string(b) != files[name] {
+				t.Errorf("Expected %s to be copied: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Linked", func(t *testing.T) {
+		in, err := os.Stat(filepath.Join(src, "data.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := os.Stat(filepath.Join(dst, "data.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !os.SameFile(in, out) {
+			t.Errorf("Expected asset to be hard-linked")
+		}
+	})
+
+	t.Run("Excluded", func(t *testing.T) {
+		if _, err := os.Stat(filepath.Join(dst, "drafts", "old.png")); !os.IsNotExist(err) {
+			t.Errorf("Copied excluded asset")
+		}
+	})
+
+	t.Run("Referenced", func(t *testing.T) {
+		page, err := os.ReadFile(filepath.Join(dst, "note", "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(page), `src="../img/My%20Plot.png"`) {
+			t.Errorf("Expected image to be linked relative to the page:\n%s", page)
+		}
+	})
+
+	t.Run("Deselected", func(t *testing.T) {
+		opts.Exclude = append(opts.Exclude, "*.csv")
+
+		if err := Build(context.Background(), src, dst, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dst, "data.csv")); !os.IsNotExist(err) {
+			t.Errorf("Output of excluded asset still exists")
+		}
+
+		if _, err := os.Stat(filepath.Join(src, "data.csv")); err != nil {
+			t.Errorf("Removed the source of a linked asset: %s", err)
+		}
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		// Neither shares an output with the other sources beside it, though
+		// their (slugified) names do.
+		removed := []string{filepath.Join(src, "img", "My Plot.png"), filepath.Join(src, "Note")}
+		for _, path := range removed {
+			if err := os.RemoveAll(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := Rebuild(context.Background(), src, dst, removed, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, out := range []string{"img/My Plot.png", "note/b/index.html"} {
+			if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(out))); !os.IsNotExist(err) {
+				t.Errorf("Output %s of removed source still exists", out)
+			}
+		}
+
+		for _, out := range []string{"img/my-plot.png", "note/index.html"} {
+			if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(out))); err != nil {
+				t.Errorf("Removed output of an existing source: %s", err)
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(dst, "note", "b")); !os.IsNotExist(err) {
+			t.Errorf("Directory of removed page still exists")
+		}
+	})
+}
+
+func TestNestedOutput(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(src, "_site")
+
+	for name, content := range map[string]string{"a.md": "# A", "data.csv": "x,y\n"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Build(context.Background(), src, dst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Rebuild(context.Background(), src, dst, []string{filepath.Join(dst, "a.html")}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.html", "data.csv"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err != nil {
+			t.Errorf("Expected %s to be built: %s", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "_site")); !os.IsNotExist(err) {
+		t.Errorf("Copied earlier output into the output directory")
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	files := map[string]string{
+		"note.md":            "# Note",
+		"drafts/idea.md":     "# Idea",
+		".obsidian/app.json": "{}",
+		"note.md~":           "# Note",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setIgnore := func(t *testing.T, patterns string) {
+		if err := os.WriteFile(filepath.Join(src, ".webtexignore"), []byte(patterns), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name)))
+
+		return err == nil
+	}
+
+	t.Run("SiteNav", func(t *testing.T) {
+		setIgnore(t, "drafts/\n")
+
+		nav, err := SiteNav(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range nav[src] {
+			if f.Name() != "note.md" {
+				t.Errorf("Expected %s to be ignored", f.Name())
+			}
+		}
+	})
+
+	t.Run("Build", func(t *testing.T) {
+		setIgnore(t, "drafts/\n")
+
+		if err := Build(context.Background(), src, dst, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !exists("note.html") {
+			t.Errorf("Expected note to be built")
+		}
+
+		for _, name := range []string{"drafts/idea.html", ".obsidian/app.json", "note.md~", ".webtexignore"} {
+			if exists(name) {
+				t.Errorf("Expected %s to be ignored", name)
+			}
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		setIgnore(t, "")
+
+		if err := Rebuild(context.Background(), src, dst, []string{filepath.Join(src, ".webtexignore")}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !exists("drafts/idea.html") {
+			t.Errorf("Expected draft to be built once no longer ignored")
+		}
+
+		setIgnore(t, "drafts/\n")
+
+		if err := Rebuild(context.Background(), src, dst, []string{filepath.Join(src, ".webtexignore")}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if exists("drafts/idea.html") {
+			t.Errorf("Expected output of ignored draft to be removed")
+		}
+	})
+}
+
+func TestSiteTex(t *testing.T) {
+	src := t.TempDir()
+
+	opts := Options{}
+	opts.Render.Tex.Preamble = "\\usepackage{amssymb}"
+
+	t.Run("Missing", func(t *testing.T) {
+		actual, err := siteTex(src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.Render.Tex != opts.Render.Tex {
+			t.Errorf("Unexpected options: %+v", actual.Render.Tex)
+		}
+	})
+
+	t.Run("Present", func(t *testing.T) {
+		files := map[string]string{
+			PreambleFile: "\\usepackage{physics}\n",
+			MacrosFile:   "\\newcommand{\\R}{\\mathbb{R}}\n",
+		}
+
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		actual, err := siteTex(src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "\\usepackage{amssymb}\n\\usepackage{physics}\n"; actual.Render.Tex.Preamble != expected {
+			t.Errorf("Expected: %q\n\nActual: %q", expected, actual.Render.Tex.Preamble)
+		}
+
+		if expected := files[MacrosFile]; actual.Render.Tex.Macros != expected {
+			t.Errorf("Expected: %q\n\nActual: %q", expected, actual.Render.Tex.Macros)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/beautifultovarisch/webtex/internal/chunk"
+)
+
+// ManifestFile is written at the root of the output directory, recording what
+// each output was built from so that later builds may skip unchanged sources.
+const ManifestFile = ".webtex-manifest.json"
+
+// Bumped whenever the output for the same source and configuration changes,
+// e.g. a change to the page template, invalidating every manifest.
+const manifestVersion = 2
+
+// source records the outputs built from a single source file.
+type source struct {
+	Hash    string   `json:"hash"`    // Hash of the content of the source.
+	Outputs []string `json:"outputs"` // Outputs built from the source, relative to the output directory.
+
+	// Links of a document to other sources, and the outputs they were routed
+	// onto (if any) when it was rendered.
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// manifest records the sources built into an output directory, keyed by their
+// paths relative to the source directory (so that the same site may be built
+// from anywhere). It is safe for concurrent use.
+type manifest struct {
+	mu sync.Mutex
+
+	Config  string            `json:"config"` // Hash of the configuration of the build.
+	Sources map[string]source `json:"sources"`
+}
+
+// Hash everything configuring a build which affects its output. Options such
+// as the number of workers and the TeX cache don't.
+func configHash(opts Options) string {
+	envs := opts.Render.Environments
+	if envs == nil {
+		envs = chunk.DefaultEnvironments
+	}
+
+	tex := opts.Render.Tex
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%q\x00%t\x00%q\x00%t\x00", manifestVersion, envs, opts.Render.Sprite, opts.LiveReload, opts.PrettyURLs)
+	fmt.Fprintf(h, "%q\x00%q\x00%t\x00%t\x00", tex.Engine, tex.Backend, tex.DVI, tex.Fonts)
+	fmt.Fprintf(h, "%q\x00%q\x00", tex.Preamble, tex.Macros)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hash the content of the file at [path].
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Load the manifest of the build of [dst] configured by [config]. A missing or
+// unreadable manifest is empty, as is one written with another configuration,
+// except that its outputs are remembered so that they can be removed.
+func loadManifest(dst, config string) *manifest {
+	m := &manifest{Sources: make(map[string]source)}
+
+	b, err := os.ReadFile(filepath.Join(dst, ManifestFile))
+	if err != nil || json.Unmarshal(b, m) != nil || m.Sources == nil {
+		return &manifest{Config: config, Sources: make(map[string]source)}
+	}
+
+	if m.Config != config {
+		for path, s := range m.Sources {
+			m.Sources[path] = source{Outputs: s.Outputs}
+		}
+
+		m.Config = config
+	}
+
+	return m
+}
+
+// Save the manifest into [dst], replacing it atomically.
+func (m *manifest) save(dst string) error {
+	m.mu.Lock()
+	b, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dst, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dst, ManifestFile)); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return nil
+}
+
+// Reports whether the outputs of the source at [rel] beneath [dst] were built
+// from content with [hash] and still exist, and whether the sources it links
+// to are still routed by [r] as they were.
+func (m *manifest) fresh(rel, hash, dst string, r routes) bool {
+	m.mu.Lock()
+	s, ok := m.Sources[rel]
+	m.mu.Unlock()
+
+	if !ok || s.Hash == "" || s.Hash != hash || !r.current(s.Links) {
+		return false
+	}
+
+	for _, out := range s.Outputs {
+		if _, err := os.Stat(filepath.Join(dst, out)); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Look up what the source at [rel] was built into.
+func (m *manifest) get(rel string) source {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.Sources[rel]
+}
+
+// Record that the source at [rel] was built into [s].
+func (m *manifest) set(rel string, s source) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Sources[rel] = s
+}
+
+// Find the sources which link to others no longer routed by [r] as they were.
+func (m *manifest) relinked(r routes) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rels []string
+	for rel, s := range m.Sources {
+		if !r.current(s.Links) {
+			rels = append(rels, rel)
+		}
+	}
+
+	slices.Sort(rels)
+
+	return rels
+}
+
+// Forget the source at [rel], along with every source beneath it if it was a
+// directory, removing their outputs from [dst].
+func (m *manifest) remove(rel, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+
+	for p, s := range m.Sources {
+		if p != rel && !strings.HasPrefix(p, rel+string(filepath.Separator)) {
+			continue
+		}
+
+		for _, out := range s.Outputs {
+			if err := os.Remove(filepath.Join(dst, out)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+
+			prune(dst, filepath.Dir(out))
+		}
+
+		delete(m.Sources, p)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Remove the directory [dir] beneath [dst], and then each of its parents, for
+// as long as they are empty.
+func prune(dst, dir string) {
+	for ; dir != "." && filepath.IsLocal(dir); dir = filepath.Dir(dir) {
+		if os.Remove(filepath.Join(dst, dir)) != nil {
+			return
+		}
+	}
+}
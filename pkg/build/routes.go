@@ -0,0 +1,196 @@
+package build
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Convert [name] into a URL-safe path element, e.g. "Exponents and Logarithms"
+// into "exponents-and-logarithms". Names without any letters or digits are
+// kept as they are.
+func slugify(name string) string {
+	var b strings.Builder
+
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '_' {
+			dash = true
+
+			continue
+		}
+
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+
+		dash = false
+		b.WriteRune(r)
+	}
+
+	if b.Len() == 0 {
+		return name
+	}
+
+	return b.String()
+}
+
+// Slugify every element of the path [rel].
+func slugifyPath(rel string) string {
+	elems := strings.Split(filepath.Clean(rel), string(filepath.Separator))
+	for i, e := range elems {
+		elems[i] = slugify(e)
+	}
+
+	return filepath.Join(elems...)
+}
+
+// Map the document at [rel], relative to the source directory, onto the path of
+// its page relative to the output directory. Only the final extension of the
+// document is replaced, e.g. notes.md.d/a.md becomes notes.md.d/a.html. With
+// [pretty], the page is instead the index of a directory named after the
+// document, e.g. a/index.html, so that it may be linked to as a/.
+func outputPath(rel string, pretty bool) string {
+	dir, name := filepath.Split(rel)
+
+	stem := slugify(strings.TrimSuffix(name, filepath.Ext(name)))
+	if pretty && stem != "index" {
+		name = filepath.Join(stem, "index.html")
+	} else {
+		name = stem + ".html"
+	}
+
+	if dir == "" {
+		return name
+	}
+
+	return filepath.Join(slugifyPath(dir), name)
+}
+
+// Map the asset at [rel], relative to the source directory, onto its path
+// relative to the output directory. Assets keep their names, but sit in the
+// same (slugified) directories as the pages beside them.
+func assetPath(rel string) string {
+	dir, name := filepath.Split(rel)
+	if dir == "" {
+		return name
+	}
+
+	return filepath.Join(slugifyPath(dir), name)
+}
+
+// Reports whether the source at [path] is a markdown document, rather than an
+// asset.
+func isDocument(path string) bool {
+	return filepath.Ext(path) == ".md"
+}
+
+// routes maps each source (document or asset), by its path relative to the
+// source directory, onto the path of its output relative to the output
+// directory.
+type routes map[string]string
+
+// Route the sources [rels]. Sources whose outputs would collide with that of
+// an earlier source are reported and left out.
+func newRoutes(rels []string, pretty bool) (routes, error) {
+	r := make(routes, len(rels))
+	owners := make(map[string]string, len(rels))
+
+	var errs []string
+
+	for _, rel := range rels {
+		out := assetPath(rel)
+		if isDocument(rel) {
+			out = outputPath(rel, pretty)
+		}
+
+		if owner, ok := owners[out]; ok {
+			errs = append(errs, fmt.Sprintf("%s: %s already built from %s", rel, out, owner))
+
+			continue
+		}
+
+		owners[out] = rel
+		r[rel] = out
+	}
+
+	if errs != nil {
+		return r, fmt.Errorf("build: %s", strings.Join(errs, "\n"))
+	}
+
+	return r, nil
+}
+
+// Relative links, e.g. href="Other%20Note.md#section" or src="plot.png".
+// Absolute URLs (which contain a scheme) are left alone.
+var linkRe = regexp.MustCompile(`(\s(?:href|src)=")([^":#?]+)([#?][^"]*)?"`)
+
+// Rewrite the links within the page of the document [rel] to other sources so
+// that they refer to their outputs instead, e.g. the pages of documents. Links
+// to anything else are left as they are.
+//
+// The sources linked to are reported along with their outputs at the time, or
+// "" for those without any (e.g. documents yet to be written), since the page
+// must be rewritten whenever they change.
+func (r routes) resolveLinks(page, rel string, pretty bool) (string, map[string]string) {
+	from := filepath.ToSlash(filepath.Dir(r[rel]))
+	links := make(map[string]string)
+
+	page = linkRe.ReplaceAllStringFunc(page, func(link string) string {
+		m := linkRe.FindStringSubmatch(link)
+
+		target, err := url.PathUnescape(html.UnescapeString(m[2]))
+		if err != nil {
+			return link
+		}
+
+		// Links are relative to the document, unless rooted at the site.
+		if strings.HasPrefix(target, "/") {
+			target = path.Clean(strings.TrimPrefix(target, "/"))
+		} else {
+			target = path.Join(path.Dir(filepath.ToSlash(rel)), target)
+		}
+
+		out, ok := r[filepath.FromSlash(target)]
+
+		links[filepath.FromSlash(target)] = out
+		if !ok {
+			return link
+		}
+
+		href, err := filepath.Rel(filepath.FromSlash(from), out)
+		if err != nil {
+			return link
+		}
+
+		href = filepath.ToSlash(href)
+
+		if pretty && path.Base(href) == "index.html" {
+			href = strings.TrimSuffix(href, "index.html")
+			if href == "" {
+				href = "./"
+			}
+		}
+
+		return m[1] + (&url.URL{Path: href}).EscapedPath() + m[3] + `"`
+	})
+
+	return page, links
+}
+
+// Reports whether every source in [links], as reported by resolveLinks, is
+// still routed onto the same output.
+func (r routes) current(links map[string]string) bool {
+	for target, out := range links {
+		if r[target] != out {
+			return false
+		}
+	}
+
+	return true
+}
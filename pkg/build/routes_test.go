@@ -0,0 +1,101 @@
+package build
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputPath(t *testing.T) {
+	for _, tc := range []struct {
+		rel, expected, pretty string
+	}{
+		{"notes.md", "notes.html", "notes/index.html"},
+		{"index.md", "index.html", "index.html"},
+		{"notes.md.d/a.md", "notes.md.d/a.html", "notes.md.d/a/index.html"},
+		{"Calculus/Exponents and Logarithms.md", "calculus/exponents-and-logarithms.html", "calculus/exponents-and-logarithms/index.html"},
+		{"Week 1: Limits (draft).md", "week-1-limits-draft.html", "week-1-limits-draft/index.html"},
+		{"Größen.md", "größen.html", "größen/index.html"},
+		{"???.md", "???.html", "???/index.html"},
+	} {
+		rel := filepath.FromSlash(tc.rel)
+
+		if actual := outputPath(rel, false); actual != filepath.FromSlash(tc.expected) {
+			t.Errorf("%s: Expected %s, got %s", tc.rel, tc.expected, actual)
+		}
+
+		if actual := outputPath(rel, true); actual != filepath.FromSlash(tc.pretty) {
+			t.Errorf("%s: Expected %s, got %s", tc.rel, tc.pretty, actual)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	t.Run("Collision", func(t *testing.T) {
+		r, err := newRoutes([]string{"A B.md", "a-b.md", "c.md"}, false)
+		if err == nil {
+			t.Error("Expected colliding pages to be reported")
+		}
+
+		if r["A B.md"] != "a-b.html" || r["c.md"] != "c.html" {
+			t.Errorf("Unexpected routes: %v", r)
+		}
+
+		if _, ok := r["a-b.md"]; ok {
+			t.Errorf("Routed colliding document")
+		}
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		rels := []string{"index.md", "Calculus/Integration.md", "Calculus/Exponents and Logarithms.md"}
+
+		for _, tc := range []struct {
+			name, rel, page, expected string
+			pretty                    bool
+		}{
+			{
+				"Sibling", "Calculus/Integration.md",
+				`<a href="Exponents%20and%20Logarithms.md#rules">`,
+				`<a href="exponents-and-logarithms.html#rules">`, false,
+			},
+			{
+				"Parent", "Calculus/Integration.md",
+				`<a href="../index.md">`,
+				`<a href="../index.html">`, false,
+			},
+			{
+				"Rooted", "index.md",
+				`<a href="/Calculus/Integration.md">`,
+				`<a href="calculus/integration.html">`, false,
+			},
+			{
+				"Pretty", "Calculus/Integration.md",
+				`<a href="Exponents%20and%20Logarithms.md">`,
+				`<a href="../exponents-and-logarithms/">`, true,
+			},
+			{
+				"PrettyIndex", "Calculus/Integration.md",
+				`<a href="../index.md">`,
+				`<a href="../../">`, true,
+			},
+			{
+				"Missing", "index.md",
+				`<a href="Missing.md">`,
+				`<a href="Missing.md">`, false,
+			},
+			{
+				"Absolute", "index.md",
+				`<a href="https://example.com/index.md">`,
+				`<a href="https://example.com/index.md">`, false,
+			},
+		} {
+			r, err := newRoutes(rels, tc.pretty)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual, _ := r.resolveLinks(tc.page, filepath.FromSlash(tc.rel), tc.pretty); actual != tc.expected {
+				t.Errorf("%s: Expected %s, got %s", tc.name, tc.expected, actual)
+			}
+		}
+	})
+}
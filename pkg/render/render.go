@@ -1,73 +1,465 @@
 // package render accepts a Markdown document potentially containing LaTeX code
 // and renders the components into HTML.
 //
-// render does not consider the overall structure of the document, instead only
-// rendering the snippets of Markdown and LaTeX into corresponding HTML code
+// The LaTeX is rendered into SVGs and substituted into the HTML rendered from
+// the surrounding Markdown.
 package render
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"html"
 	"io"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/beautifultovarisch/webtex/internal/chunk"
+	"github.com/beautifultovarisch/webtex/internal/frontmatter"
 	"github.com/beautifultovarisch/webtex/internal/mdrender"
+	"github.com/beautifultovarisch/webtex/internal/svgedit"
 	"github.com/beautifultovarisch/webtex/internal/texrender"
 )
 
-func renderMd(c chunk.Chunk) string {
-	if c.T != chunk.MD {
-		panic("Implementation error. Expected markdown chunk")
+// Indirection so that the pipeline can be tested without a TeX installation.
+var (
+	texBlock  = texrender.RenderBlock
+	texInline = texrender.RenderInline
+	texEnv    = texrender.RenderEnvironment
+)
+
+// Options configure a Renderer.
+type Options struct {
+	Workers int               // Workers bounds the number of concurrent TeX renders. Defaults to the number of CPUs.
+	Tex     texrender.Options // Tex is passed through to texrender for every LaTeX chunk.
+
+	// Environments lists the LaTeX environments rendered without math
+	// delimiters. Defaults to chunk.DefaultEnvironments.
+	Environments []string
+
+	// Sprite, if set, defines each distinct glyph once in a hidden SVG at the
+	// top of the document, rather than in every SVG using it. Output is then
+	// only written once the whole document has been rendered.
+	Sprite bool
+}
+
+// Renderer renders Markdown documents, dispatching LaTeX chunks to a bounded
+// pool of workers. A Renderer may be shared by many goroutines, in which case
+// the bound applies to all documents being rendered.
+type Renderer struct {
+	opts Options
+	sem  chan struct{} // Holds a token for each TeX render in flight.
+}
+
+// New creates a Renderer configured with [opts].
+func New(opts Options) *Renderer {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
 	}
 
-	return mdrender.Render(c.Content)
+	return &Renderer{opts: opts, sem: make(chan struct{}, opts.Workers)}
 }
 
-func renderBlock(c chunk.Chunk) (string, error) {
+var (
+	defaultRenderer *Renderer
+	defaultOnce     sync.Once
+)
+
+func renderBlock(ctx context.Context, c chunk.Chunk, opts texrender.Options) (string, error) {
 	if c.T != chunk.BLOCK {
 		panic("Implementation error. Expected LaTeX block")
 	}
 
-	return texrender.RenderBlock(c.Content)
+	return texBlock(ctx, c.Content, opts)
 }
 
-func renderInline(c chunk.Chunk) (string, error) {
+func renderInline(ctx context.Context, c chunk.Chunk, opts texrender.Options) (string, error) {
 	if c.T != chunk.INLINE {
 		panic("Implementation error. Expected inline LaTeX")
 	}
 
-	return texrender.RenderInline(c.Content)
+	return texInline(ctx, c.Content, opts)
+}
+
+func renderEnv(ctx context.Context, c chunk.Chunk, opts texrender.Options) (string, error) {
+	if c.T != chunk.ENV {
+		panic("Implementation error. Expected LaTeX environment")
+	}
+
+	return texEnv(ctx, c.Content, opts)
 }
 
-func processChunk(c chunk.Chunk) (string, error) {
+func processChunk(ctx context.Context, c chunk.Chunk, opts texrender.Options) (string, error) {
 	switch c.T {
-	case chunk.MD:
-		return renderMd(c), nil
 	case chunk.INLINE:
-		return renderInline(c)
+		return renderInline(ctx, c, opts)
 	case chunk.BLOCK:
-		return renderBlock(c)
+		return renderBlock(ctx, c, opts)
+	case chunk.ENV:
+		return renderEnv(ctx, c, opts)
 	}
 
 	return "", nil
 }
 
-// RenderDoc accepts a string containing an individual markdown document and
-// writes an HTML document with the rendered content of [md] to [out].
-func RenderDoc(md io.Reader, out io.Writer) error {
-	buf := bufio.NewReader(md)
+// Attribute [err] to the location of [c] within the document. Errors reported
+// by TeX are narrowed down to the offending line.
+func locate(c chunk.Chunk, err error) error {
+	pos := c.Start
+
+	var texErr *texrender.TexError
+	if errors.As(err, &texErr) && texErr.Line > 1 {
+		// The content of a chunk begins on the same line as its delimiter.
+		pos = chunk.Position{Line: c.Start.Line + texErr.Line - 1, Column: 1}
+	}
+
+	return fmt.Errorf("%s: %w", pos, err)
+}
+
+// result is the rendered output of a single chunk.
+type result struct {
+	html string
+	err  error
+}
+
+// Placeholders stand in for LaTeX chunks while the markdown is rendered. They
+// are alphanumeric so that gomarkdown passes them through untouched, and
+// upper case so that they can't be confused with heading IDs derived from them.
+type placeholders struct {
+	prefix string
+	re     *regexp.Regexp // Matches a placeholder, along with any paragraph it forms.
+	ids    *regexp.Regexp // Matches a placeholder within a heading ID.
+}
+
+// Choose placeholders which don't occur anywhere in [md]. They are chosen
+// deterministically so that heading IDs are stable.
+func newPlaceholders(md string) placeholders {
+	md = strings.ToUpper(md)
+
+	prefix := "WEBTEXMATH"
+	for n := 1; strings.Contains(md, prefix); n++ {
+		prefix = fmt.Sprintf("WEBTEXMATH%d", n)
+	}
+
+	return placeholders{
+		prefix: prefix,
+		// Display LaTeX forming a paragraph of its own is unwrapped from it.
+		re:  regexp.MustCompile(`(<p>)?` + prefix + `M(\d+)Z(</p>)?`),
+		ids: regexp.MustCompile(strings.ToLower(prefix) + `m\d+z`),
+	}
+}
+
+func (p placeholders) format(i int) string {
+	return fmt.Sprintf("%sM%dZ", p.prefix, i)
+}
+
+// Extract the [n]th submatch of [s] from the indices [m], which is empty if the
+// group didn't participate in the match.
+func submatch(s string, m []int, n int) string {
+	if m[2*n] < 0 {
+		return ""
+	}
+
+	return s[m[2*n]:m[2*n+1]]
+}
+
+// Reports whether the end of the HTML [s] lies within a tag (e.g. within the
+// value of an attribute), given whether its start does.
+func withinTag(s string, inTag bool) bool {
+	open, close := strings.LastIndexByte(s, '<'), strings.LastIndexByte(s, '>')
+	if open == close {
+		// Neither occurs.
+		return inTag
+	}
+
+	return open > close
+}
+
+// Join sections of LaTeX, each beginning on a new line.
+func joinLines(a, b string) string {
+	if a != "" && b != "" && !strings.HasSuffix(a, "\n") {
+		a += "\n"
+	}
+
+	return a + b
+}
+
+// document is a markdown document split into its markdown and LaTeX.
+type document struct {
+	md   string              // The markdown, in which every LaTeX chunk is replaced with a placeholder.
+	math []chunk.Chunk       // The LaTeX chunks, in document order.
+	ph   placeholders        // The placeholders standing in for math.
+	tex  []texrender.Options // Options for rendering each of math, including any preamble and macros of the document's own.
+}
+
+// Lex [md] into a document.
+func (r *Renderer) lex(md io.Reader) (*document, error) {
+	lx := chunk.NewLexer(md)
+	if r.opts.Environments != nil {
+		lx.SetEnvironments(r.opts.Environments)
+	}
+
+	var chunks []chunk.Chunk
 
-	// We can stream the output of processChunk directly to out.
 	for {
-		c, err := chunk.ChunkDoc(buf)
-		if err != nil {
-			return err
+		c, err := lx.Next()
+		if err == io.EOF {
+			break
 		}
 
-		html, err := processChunk(c)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		chunks = append(chunks, c)
+	}
+
+	tex := r.opts.Tex
+
+	var text strings.Builder
+	for _, c := range chunks {
+		if c.T == chunk.MD {
+			text.WriteString(c.Content)
+		}
+	}
+
+	ph := newPlaceholders(text.String())
+
+	var (
+		doc  strings.Builder
+		math []chunk.Chunk
+		opts []texrender.Options
+	)
+
+	for _, c := range chunks {
+		switch c.T {
+		case chunk.MD:
+			doc.WriteString(c.Content)
+		case chunk.FRONT:
+			front, err := frontmatter.Parse(c.Content)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", c.Start, err)
+			}
+
+			// The document's preamble extends that of the site.
+			tex.Preamble = joinLines(tex.Preamble, front.TexPreamble())
+		case chunk.MACROS:
+			// Macros apply to every subsequent formula.
+			tex.Macros = joinLines(tex.Macros, c.Content)
+		default:
+			o := tex
+
+			for k, v := range c.Attrs {
+				if k != "engine" {
+					return nil, fmt.Errorf("%s: unknown attribute %q", c.Start, k)
+				}
+
+				engine, err := texrender.ParseEngine(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", c.Start, err)
+				}
+
+				o.Engine = engine
+			}
+
+			doc.WriteString(ph.format(len(math)))
+			math = append(math, c)
+			opts = append(opts, o)
+		}
+	}
+
+	return &document{md: doc.String(), math: math, ph: ph, tex: opts}, nil
+}
+
+// Render every LaTeX chunk of [doc] once a worker is available, resolving the
+// corresponding future. Returns once every render has finished, dispatching no
+// more (and killing those in flight) once [ctx] is done.
+func (r *Renderer) dispatch(ctx context.Context, doc *document, futures []chan result) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i, c := range doc.math {
+		select {
+		case r.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		wg.Add(1)
+		go func(i int, c chunk.Chunk) {
+			defer wg.Done()
+			defer func() { <-r.sem }()
+
+			html, err := processChunk(ctx, c, doc.tex[i])
+			if err != nil {
+				err = locate(c, err)
+			}
+
+			futures[i] <- result{html, err}
+		}(i, c)
+	}
+}
+
+// RenderDoc reads an individual markdown document from [md] and writes the
+// rendered HTML to [out].
+//
+// LaTeX chunks are replaced with placeholders so that the markdown may be
+// rendered as a whole, preserving lists, tables and the like which contain
+// LaTeX. The markdown is rendered while the LaTeX is, and output is streamed
+// as soon as each LaTeX chunk (and every chunk preceding it) has been rendered.
+//
+// Errors rendering a chunk are prefixed with its line and column in [md]. If
+// [ctx] is done before the document has been rendered, any TeX in flight is
+// killed and the error of [ctx] is returned.
+func (r *Renderer) RenderDoc(ctx context.Context, md io.Reader, out io.Writer) error {
+	doc, err := r.lex(md)
+	if err != nil {
+		return err
+	}
+
+	math, ph := doc.math, doc.ph
+
+	futures := make([]chan result, len(math))
+	for i := range futures {
+		futures[i] = make(chan result, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		r.dispatch(ctx, doc, futures)
+	}()
+
+	// Stop dispatching and wait for any in-flight renders to be killed.
+	abort := func(err error) error {
+		cancel()
+		<-finished
+
+		return err
+	}
+
+	page := ph.ids.ReplaceAllString(mdrender.Render(doc.md), "math")
+
+	w := out
+
+	var (
+		sprite *svgedit.Sprite
+		body   strings.Builder
+	)
+
+	// The sprite must precede every SVG referring to it, so hold the output
+	// back until all have been rendered.
+	if r.opts.Sprite {
+		sprite = svgedit.NewSprite("glyph-")
+		w = &body
+	}
+
+	// A placeholder may appear more than once (e.g. in the table of contents)
+	// or not at all, so results are kept once received.
+	results := make([]*result, len(math))
+	resolve := func(i int) result {
+		if results[i] == nil {
+			var res result
+
+			// Once cancelled, chunks yet to be dispatched will never resolve.
+			select {
+			case res = <-futures[i]:
+			case <-ctx.Done():
+				res = result{err: ctx.Err()}
+			}
+
+			results[i] = &res
 		}
 
-		io.WriteString(out, html)
+		return *results[i]
 	}
+
+	// Every SVG defines the same IDs, which must be unique within the page,
+	// including those of a chunk appearing more than once.
+	occurrences := make([]int, len(math))
+
+	last, inTag := 0, false
+	for _, m := range ph.re.FindAllStringSubmatchIndex(page, -1) {
+		i, err := strconv.Atoi(submatch(page, m, 2))
+		if err != nil || i >= len(math) {
+			continue
+		}
+
+		// An SVG can't appear within a tag, e.g. in the alt text of an image
+		// or the URL of a link, so the LaTeX stands in for itself there.
+		if inTag = withinTag(page[last:m[0]], inTag); inTag {
+			tex := html.EscapeString(strings.TrimSpace(math[i].Content))
+
+			if _, err := io.WriteString(w, page[last:m[0]]+submatch(page, m, 1)+tex+submatch(page, m, 3)); err != nil {
+				return abort(err)
+			}
+
+			last = m[1]
+
+			continue
+		}
+
+		res := resolve(i)
+		if res.err != nil {
+			return abort(res.err)
+		}
+
+		open, close := submatch(page, m, 1), submatch(page, m, 3)
+		if open != "" && close != "" && math[i].T != chunk.INLINE {
+			open, close = "", ""
+		}
+
+		svg := svgedit.Prefix(res.html, fmt.Sprintf("m%d-%d-", i, occurrences[i]))
+		occurrences[i]++
+
+		if sprite != nil {
+			svg = sprite.Add(svg)
+		}
+
+		if _, err := io.WriteString(w, page[last:m[0]]+open+svg+close); err != nil {
+			return abort(err)
+		}
+
+		last = m[1]
+	}
+
+	if _, err := io.WriteString(w, page[last:]); err != nil {
+		return abort(err)
+	}
+
+	// Report failures of any LaTeX which didn't make it into the output.
+	for i := range math {
+		if res := resolve(i); res.err != nil {
+			return abort(res.err)
+		}
+	}
+
+	<-finished
+
+	if sprite != nil {
+		if _, err := io.WriteString(out, sprite.String()+body.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RenderDoc accepts a string containing an individual markdown document and
+// writes an HTML document with the rendered content of [md] to [out] using a
+// Renderer with the default Options.
+func RenderDoc(ctx context.Context, md io.Reader, out io.Writer) error {
+	defaultOnce.Do(func() {
+		defaultRenderer = New(Options{})
+	})
+
+	return defaultRenderer.RenderDoc(ctx, md, out)
 }
@@ -1,9 +1,18 @@
 package render
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/beautifultovarisch/webtex/internal/texrender"
 )
 
 // TODO: These types of tests are a good fit for snapshot testing. Look into
@@ -15,6 +24,324 @@ func TestRenderDoc(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		RenderDoc(doc, io.Discard)
+		RenderDoc(context.Background(), doc, io.Discard)
+	})
+}
+
+// Replace texrender with a stub that records the peak number of concurrent
+// renders and finishes chunks out of order.
+func stubTex(t *testing.T) *atomic.Int32 {
+	var active, peak atomic.Int32
+
+	stub := func(kind string) func(context.Context, string, texrender.Options) (string, error) {
+		return func(_ context.Context, tex string, _ texrender.Options) (string, error) {
+			n := active.Add(1)
+			defer active.Add(-1)
+
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+
+			return fmt.Sprintf("<%s>%s</%s>", kind, tex, kind), nil
+		}
+	}
+
+	block, inline, env := texBlock, texInline, texEnv
+	texBlock, texInline, texEnv = stub("block"), stub("inline"), stub("env")
+
+	t.Cleanup(func() { texBlock, texInline, texEnv = block, inline, env })
+
+	return &peak
+}
+
+func TestRenderer(t *testing.T) {
+	t.Run("Ordered", func(t *testing.T) {
+		stubTex(t)
+
+		var doc, expected strings.Builder
+		for i := 0; i < 40; i++ {
+			fmt.Fprintf(&doc, "$$%d$$$%d$", i, i)
+			fmt.Fprintf(&expected, "<block>%d</block><inline>%d</inline>", i, i)
+		}
+
+		var out strings.Builder
+		if err := New(Options{Workers: 8}).RenderDoc(context.Background(), strings.NewReader(doc.String()), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != "<p>"+expected.String()+"</p>\n" {
+			t.Errorf("Expected:\n%s\nActual:\n%s", expected.String(), out.String())
+		}
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		peak := stubTex(t)
+
+		doc := strings.Repeat("$$x$$", 50)
+
+		if err := New(Options{Workers: 3}).RenderDoc(context.Background(), strings.NewReader(doc), io.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		if p := peak.Load(); p > 3 {
+			t.Errorf("Expected at most 3 concurrent renders, saw %d", p)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		stubTex(t)
+
+		texBlock = func(context.Context, string, texrender.Options) (string, error) {
+			return "", errors.New("pdflatex exploded")
+		}
+
+		doc := strings.Repeat("text $x$ $$y$$ ", 20)
+
+		if err := New(Options{Workers: 2}).RenderDoc(context.Background(), strings.NewReader(doc), io.Discard); err == nil {
+			t.Errorf("Expected render error to be reported")
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		stubTex(t)
+
+		// Renders hang until killed.
+		texBlock = func(ctx context.Context, _ string, _ texrender.Options) (string, error) {
+			<-ctx.Done()
+
+			return "", ctx.Err()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		doc := strings.Repeat("text $x$ $$y$$ ", 20)
+
+		err := New(Options{Workers: 2}).RenderDoc(ctx, strings.NewReader(doc), io.Discard)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected cancellation to be reported, got %v", err)
+		}
+	})
+
+	t.Run("Environments", func(t *testing.T) {
+		stubTex(t)
+
+		doc := "\\begin{align}x\\end{align}\n\\begin{cases}y\\end{cases}\n"
+
+		for _, tc := range []struct {
+			envs     []string
+			expected string
+		}{
+			{nil, "<env>\\begin{align}x\\end{align}</env>"},
+			{[]string{"cases"}, "<env>\\begin{cases}y\\end{cases}</env>"},
+		} {
+			var out strings.Builder
+			if err := New(Options{Environments: tc.envs}).RenderDoc(context.Background(), strings.NewReader(doc), &out); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(out.String(), tc.expected) {
+				t.Errorf("Expected %s in:\n%s", tc.expected, out.String())
+			}
+		}
+	})
+
+	t.Run("ListEnvironment", func(t *testing.T) {
+		stubTex(t)
+
+		// An environment opening a list item is rendered within it.
+		doc := "1. \\begin{align}x\\end{align}\n2. Second\n"
+
+		var out strings.Builder
+		if err := New(Options{}).RenderDoc(context.Background(), strings.NewReader(doc), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "<ol>\n<li><env>\\begin{align}x\\end{align}</env></li>\n<li>Second</li>\n</ol>\n"
+		if out.String() != expected {
+			t.Errorf("Expected: %q\n\nActual: %q", expected, out.String())
+		}
+	})
+
+	// The markdown surrounding LaTeX is rendered as a whole.
+	t.Run("Structure", func(t *testing.T) {
+		stubTex(t)
+
+		for _, tc := range []struct {
+			name, doc, expected string
+		}{
+			{"List", "- $a$ one\n- *two $b$ three*\n", "<ul>\n<li><inline>a</inline> one</li>\n<li><em>two <inline>b</inline> three</em></li>\n</ul>\n"},
+			{"Table", "| x | y |\n|---|---|\n| $a$ | $$b$$ |\n", "<td><inline>a</inline></td>\n<td><block>b</block></td>"},
+			{"Display", "Text\n\n$$\nx\n$$\n\nmore $y$.\n", "<p>Text</p>\n\n<block>\nx\n</block>\n\n<p>more <inline>y</inline>.</p>\n"},
+			{"Heading", "# Area $A$\n", `<h1 id="area-math">Area <inline>A</inline></h1>`},
+			{"Repeated", "$a$ $b$ $a$\n", "<p><inline>a</inline> <inline>b</inline> <inline>a</inline></p>\n"},
+			{"Alt", "![area $a<b$](img.png)\n", `alt="area a&lt;b"`},
+			{"Href", "[l](http://e.com/$y$) $z$\n", `<a href="http://e.com/y" target="_blank">l</a> <inline>z</inline>`},
+		} {
+			var out strings.Builder
+			if err := New(Options{}).RenderDoc(context.Background(), strings.NewReader(tc.doc), &out); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(out.String(), tc.expected) {
+				t.Errorf("%s: Expected:\n%s\nActual:\n%s", tc.name, tc.expected, out.String())
+			}
+		}
+	})
+
+	t.Run("UniqueIDs", func(t *testing.T) {
+		stubTex(t)
+
+		texInline = func(context.Context, string, texrender.Options) (string, error) {
+			return `<svg><symbol id="glyph0-1"/><use xlink:href="#glyph0-1"/></svg>`, nil
+		}
+
+		// Headings are repeated in the table of contents.
+		var out strings.Builder
+		if err := New(Options{}).RenderDoc(context.Background(), strings.NewReader("# Area $A$\n\n$a$ $b$"), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range []string{"m0-0-glyph0-1", "m0-1-glyph0-1", "m1-0-glyph0-1", "m2-0-glyph0-1"} {
+			if strings.Count(out.String(), `id="`+id+`"`) != 1 || !strings.Contains(out.String(), `href="#`+id+`"`) {
+				t.Errorf("Expected a single definition of %s, and references to it, in:\n%s", id, out.String())
+			}
+		}
+	})
+
+	t.Run("Sprite", func(t *testing.T) {
+		stubTex(t)
+
+		texInline = func(context.Context, string, texrender.Options) (string, error) {
+			return `<svg><symbol id="glyph0-1"><path d="M 0 0 Z"/></symbol><use xlink:href="#glyph0-1"/></svg>`, nil
+		}
+
+		var out strings.Builder
+		if err := New(Options{Sprite: true}).RenderDoc(context.Background(), strings.NewReader("$a$ $b$"), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := strings.Count(out.String(), "<symbol"); n != 1 {
+			t.Errorf("Expected a single symbol, got %d:\n%s", n, out.String())
+		}
+
+		if !strings.HasPrefix(out.String(), "<svg") || strings.Count(out.String(), `href="#glyph-0"`) != 2 {
+			t.Errorf("Expected formulas to refer to the sprite:\n%s", out.String())
+		}
+	})
+
+	t.Run("Preamble", func(t *testing.T) {
+		stubTex(t)
+
+		texInline = func(_ context.Context, _ string, opts texrender.Options) (string, error) {
+			return opts.Preamble, nil
+		}
+
+		doc := "---\npackages: [physics]\n---\n$x$"
+		opts := Options{Tex: texrender.Options{Preamble: `\usepackage{amssymb}`}}
+
+		var out strings.Builder
+		if err := New(opts).RenderDoc(context.Background(), strings.NewReader(doc), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "<p>\\usepackage{amssymb}\n\\usepackage{physics}\n</p>\n"
+		if out.String() != expected {
+			t.Errorf("Expected: %q\n\nActual: %q", expected, out.String())
+		}
+
+		// Other documents are unaffected.
+		out.Reset()
+		if err := New(opts).RenderDoc(context.Background(), strings.NewReader("$x$"), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != "<p>\\usepackage{amssymb}</p>\n" {
+			t.Errorf("Unexpected preamble: %q", out.String())
+		}
+	})
+
+	t.Run("InvalidFrontmatter", func(t *testing.T) {
+		stubTex(t)
+
+		// What doesn't parse as frontmatter is rendered as markdown.
+		doc := "---\nSome prose, set between rules.\n\n---\n"
+
+		var out strings.Builder
+		if err := New(Options{}).RenderDoc(context.Background(), strings.NewReader(doc), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "<hr>\n\n<p>Some prose, set between rules.</p>\n\n<hr>\n"
+		if out.String() != expected {
+			t.Errorf("Expected: %q\n\nActual: %q", expected, out.String())
+		}
+	})
+
+	t.Run("Macros", func(t *testing.T) {
+		stubTex(t)
+
+		texInline = func(_ context.Context, tex string, opts texrender.Options) (string, error) {
+			return fmt.Sprintf("[%s: %s]", tex, opts.Macros), nil
+		}
+
+		doc := "$a$\n\n```latex-macros\n\\def\\x{1}\n```\n\n$b$\n"
+		opts := Options{Tex: texrender.Options{Macros: `\def\R{\mathbb{R}}`}}
+
+		var out strings.Builder
+		if err := New(opts).RenderDoc(context.Background(), strings.NewReader(doc), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only formulas following the macros may use them.
+		expected := "<p>[a: \\def\\R{\\mathbb{R}}]</p>\n\n<p>[b: \\def\\R{\\mathbb{R}}\n\\def\\x{1}\n]</p>\n"
+		if out.String() != expected {
+			t.Errorf("Expected: %s\n\nActual: %s", expected, out.String())
+		}
+	})
+
+	t.Run("Engine", func(t *testing.T) {
+		stubTex(t)
+
+		texBlock = func(_ context.Context, _ string, opts texrender.Options) (string, error) {
+			return string(opts.Engine), nil
+		}
+
+		doc := "$$x$$\n\n```tex engine=lualatex\nx\n```\n"
+
+		var out strings.Builder
+		if err := New(Options{Tex: texrender.Options{Engine: texrender.XeLaTeX}}).RenderDoc(context.Background(), strings.NewReader(doc), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "xelatex\n\nlualatex\n"; out.String() != expected {
+			t.Errorf("Expected: %q\n\nActual: %q", expected, out.String())
+		}
+
+		for _, doc := range []string{"```tex engine=latex\nx\n```", "```tex colour=red\nx\n```"} {
+			if err := New(Options{}).RenderDoc(context.Background(), strings.NewReader(doc), io.Discard); err == nil || !strings.HasPrefix(err.Error(), "1:1: ") {
+				t.Errorf("Expected error on line 1, got: %v", err)
+			}
+		}
+	})
+
+	t.Run("Location", func(t *testing.T) {
+		stubTex(t)
+
+		texBlock = func(context.Context, string, texrender.Options) (string, error) {
+			return "", &texrender.TexError{Message: "Undefined control sequence.", Line: 2}
+		}
+
+		doc := "# Heading\n\n$$\n\\drw\n$$\n"
+
+		err := New(Options{}).RenderDoc(context.Background(), strings.NewReader(doc), io.Discard)
+		if err == nil || !strings.HasPrefix(err.Error(), "4:1: ") {
+			t.Errorf("Expected error on line 4, got: %v", err)
+		}
 	})
 }